@@ -0,0 +1,426 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	termutil "github.com/andrew-d/go-termutil"
+	"github.com/spf13/viper"
+)
+
+const (
+	// attachFileLimit is the size of the largest file that's attached.
+	attachFileLimit = 256 << 10
+	// defaultAttachWarnTokens is how many tokens of attachments are sent
+	// without asking, unless `attach-warn-tokens` says otherwise.
+	defaultAttachWarnTokens = 10000
+)
+
+// attachSpec is what --file and --glob asked to attach.
+type attachSpec struct {
+	files []string
+	globs []string
+}
+
+// attachFlags are set by --file and --glob on uh and chat.
+var attachFlags attachSpec
+
+// errNotSent is returned when the user decides not to send a large prompt.
+var errNotSent = errors.New("not sent")
+
+// attachment is a file added to a prompt.
+type attachment struct {
+	Path     string
+	Language string
+	Content  string
+}
+
+// mentionPattern finds @path mentions, which only count when the path
+// exists or, for patterns, matches something.
+var mentionPattern = regexp.MustCompile(`(^|\s)@([^\s@]+)`)
+
+// attachFiles adds the files in spec and the ones mentioned with @path in
+// typed to the end of prompt, as fenced blocks. typed is the part of prompt
+// the user typed: piped input isn't scanned for mentions, so it can't pull in
+// files of its own. Files that are too big or binary are left out with a
+// notice. When the files add up to more tokens than `attach-warn-tokens`, or
+// more than fit in the persona's context window, the user is asked before
+// sending, unless --force is given or no one is there to ask.
+func attachFiles(persona Persona, prompt string, typed string, spec attachSpec, notice func(string)) (string, error) {
+	paths := []string{}
+	for _, file := range spec.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		if info.IsDir() {
+			return "", fmt.Errorf("%s is a directory, attach it with --glob '%s'", file, filepath.Join(file, "**"))
+		}
+		paths = append(paths, file)
+	}
+	for _, pattern := range spec.globs {
+		matches, err := globFiles(pattern)
+		if err != nil {
+			return "", err
+		}
+		if len(matches) == 0 {
+			notice("nothing matches " + pattern)
+		}
+		paths = append(paths, matches...)
+	}
+	paths = append(paths, mentionedFiles(typed)...)
+	if len(paths) == 0 {
+		return prompt, nil
+	}
+
+	attachments := []attachment{}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if seen[filepath.Clean(path)] {
+			continue
+		}
+		seen[filepath.Clean(path)] = true
+		attached, err := readAttachment(path)
+		if err != nil {
+			notice("left out " + path + ": " + err.Error())
+			continue
+		}
+		attachments = append(attachments, attached)
+	}
+	if len(attachments) == 0 {
+		return prompt, nil
+	}
+
+	blocks := []string{}
+	tokens := 0
+	for _, attached := range attachments {
+		block := attached.Block()
+		blocks = append(blocks, block)
+		tokens += estimateTokens(block)
+	}
+	files := "1 file"
+	if len(attachments) != 1 {
+		files = fmt.Sprintf("%d files", len(attachments))
+	}
+	notice(fmt.Sprintf("attached %s, about %d tokens", files, tokens))
+
+	limit := defaultAttachWarnTokens
+	if n, err := strconv.Atoi(personaSetting(persona, "attach-warn-tokens")); err == nil && n > 0 {
+		limit = n
+	}
+	window := contextWindow(persona) - contextReserve(persona)
+	if tokens > limit || tokens > window {
+		warning := fmt.Sprintf("the attached files are about %d tokens", tokens)
+		if tokens > window {
+			warning += fmt.Sprintf(", more than the %d that fit in the context window of %s", window, persona.Model)
+		}
+		fmt.Fprintln(os.Stderr, "warning: "+warning)
+		if !viper.GetBool("force") && termutil.Isatty(os.Stdin.Fd()) && termutil.Isatty(os.Stdout.Fd()) {
+			fmt.Println("send them anyway?")
+			if !confirmWithUser() {
+				return "", errNotSent
+			}
+		}
+	}
+
+	if strings.TrimSpace(prompt) != "" {
+		blocks = append([]string{strings.TrimRight(prompt, "\n")}, blocks...)
+	}
+	return strings.Join(blocks, "\n\n"), nil
+}
+
+// mentionedFiles returns the files named by @path mentions in prompt.
+// Trailing punctuation is ignored, so "look at @main.go." works.
+func mentionedFiles(prompt string) []string {
+	paths := []string{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(prompt, -1) {
+		mention := match[2]
+		if strings.ContainsAny(mention, "*?[") {
+			if matches, err := globFiles(mention); err == nil {
+				paths = append(paths, matches...)
+			}
+			continue
+		}
+		for candidate := mention; candidate != ""; candidate = candidate[:len(candidate)-1] {
+			if info, err := os.Stat(expandHome(candidate)); err == nil && !info.IsDir() {
+				paths = append(paths, expandHome(candidate))
+				break
+			}
+			if !strings.ContainsRune(".,;:!?)'\"", rune(candidate[len(candidate)-1])) {
+				break
+			}
+		}
+	}
+	return paths
+}
+
+// readAttachment reads a file to attach, refusing ones that are too big or
+// aren't text.
+func readAttachment(path string) (attachment, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return attachment{}, err
+	}
+	if info.Size() > attachFileLimit {
+		return attachment{}, fmt.Errorf("it's %s, more than the %s limit", formatSize(info.Size()), formatSize(attachFileLimit))
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return attachment{}, err
+	}
+	if strings.ContainsRune(string(content), 0) || !utf8.Valid(content) {
+		return attachment{}, errors.New("it's not a text file")
+	}
+	return attachment{Path: path, Language: detectLanguage(path, string(content)), Content: string(content)}, nil
+}
+
+// Block is the attachment as a fenced block labelled with its path. The
+// fence is made longer than any fence inside the file.
+func (a attachment) Block() string {
+	fence := "```"
+	for strings.Contains(a.Content, fence) {
+		fence += "`"
+	}
+	return "### " + a.Path + "\n\n" + fence + a.Language + "\n" + strings.TrimRight(a.Content, "\n") + "\n" + fence
+}
+
+// languages maps file extensions to the language names markdown uses.
+var languages = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".jsx":   "jsx",
+	".ts":    "typescript",
+	".tsx":   "tsx",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".java":  "java",
+	".kt":    "kotlin",
+	".swift": "swift",
+	".c":     "c",
+	".h":     "c",
+	".cpp":   "cpp",
+	".cc":    "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".php":   "php",
+	".lua":   "lua",
+	".sh":    "bash",
+	".bash":  "bash",
+	".zsh":   "zsh",
+	".fish":  "fish",
+	".ps1":   "powershell",
+	".sql":   "sql",
+	".html":  "html",
+	".css":   "css",
+	".scss":  "scss",
+	".json":  "json",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".toml":  "toml",
+	".ini":   "ini",
+	".xml":   "xml",
+	".md":    "markdown",
+	".tf":    "hcl",
+	".nix":   "nix",
+	".vim":   "vim",
+	".el":    "elisp",
+	".ex":    "elixir",
+	".exs":   "elixir",
+	".hs":    "haskell",
+	".ml":    "ocaml",
+	".zig":   "zig",
+	".proto": "protobuf",
+	".diff":  "diff",
+	".patch": "diff",
+}
+
+// languageFiles are files known by their whole name.
+var languageFiles = map[string]string{
+	"Makefile":      "makefile",
+	"Dockerfile":    "dockerfile",
+	"Containerfile": "dockerfile",
+	"Jenkinsfile":   "groovy",
+	"go.mod":        "go",
+	"PKGBUILD":      "bash",
+}
+
+// detectLanguage names the language of a file from its name, or its shebang
+// line, or returns "" if it can't tell.
+func detectLanguage(path string, content string) string {
+	if language, ok := languageFiles[filepath.Base(path)]; ok {
+		return language
+	}
+	if language, ok := languages[strings.ToLower(filepath.Ext(path))]; ok {
+		return language
+	}
+	if shebang, ok := strings.CutPrefix(content, "#!"); ok {
+		line, _, _ := strings.Cut(shebang, "\n")
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			interpreter := filepath.Base(fields[0])
+			if interpreter == "env" {
+				// skip env's own flags, like -S
+				for _, field := range fields[1:] {
+					if !strings.HasPrefix(field, "-") {
+						interpreter = field
+						break
+					}
+				}
+			}
+			switch {
+			case interpreter == "sh" || interpreter == "bash":
+				return "bash"
+			case strings.HasPrefix(interpreter, "python"):
+				return "python"
+			case interpreter == "node":
+				return "javascript"
+			default:
+				return interpreter
+			}
+		}
+	}
+	return ""
+}
+
+// globFiles returns the files matching pattern, in order. `**` matches any
+// number of directories. Hidden files and directories are only matched by
+// patterns that name them.
+func globFiles(pattern string) ([]string, error) {
+	pattern = filepath.ToSlash(expandHome(pattern))
+	parts := strings.Split(pattern, "/")
+	// walk from the deepest directory without wildcards
+	root := []string{}
+	for len(parts) > 1 && !strings.ContainsAny(parts[0], "*?[") {
+		root = append(root, parts[0])
+		parts = parts[1:]
+	}
+	base := strings.Join(root, "/")
+	if base == "" && strings.HasPrefix(pattern, "/") {
+		base = "/"
+	} else if base == "" {
+		base = "."
+	}
+	for _, part := range parts {
+		if _, err := filepath.Match(part, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %s: %w", pattern, err)
+		}
+	}
+
+	matches := []string{}
+	err := filepath.WalkDir(filepath.FromSlash(base), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == filepath.FromSlash(base) {
+				return filepath.SkipAll
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(filepath.FromSlash(base), path)
+		if err != nil || rel == "." {
+			return nil
+		}
+		segments := strings.Split(filepath.ToSlash(rel), "/")
+		if entry.IsDir() {
+			if !globPrefixMatches(parts, segments) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if globMatches(parts, segments) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// globMatches reports whether the path segments match the pattern's.
+func globMatches(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if i > 0 && strings.HasPrefix(segments[i-1], ".") {
+				return false
+			}
+			if globMatches(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 || !globSegmentMatches(pattern[0], segments[0]) {
+		return false
+	}
+	return globMatches(pattern[1:], segments[1:])
+}
+
+// globPrefixMatches reports whether files under a directory at segments
+// could still match the pattern, so it's worth walking into.
+func globPrefixMatches(pattern []string, segments []string) bool {
+	for i, segment := range segments {
+		if i >= len(pattern) {
+			return false
+		}
+		if pattern[i] == "**" {
+			for _, rest := range segments[i:] {
+				if strings.HasPrefix(rest, ".") {
+					return false
+				}
+			}
+			return true
+		}
+		if !globSegmentMatches(pattern[i], segment) {
+			return false
+		}
+	}
+	return true
+}
+
+func globSegmentMatches(pattern string, segment string) bool {
+	if strings.HasPrefix(segment, ".") && !strings.HasPrefix(pattern, ".") {
+		return false
+	}
+	matched, _ := filepath.Match(pattern, segment)
+	return matched
+}
+
+// expandHome replaces a leading ~/ with the home directory.
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
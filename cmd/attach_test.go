@@ -0,0 +1,167 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testFiles writes empty files at paths under dir.
+func testFiles(t *testing.T, dir string, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testChdir moves the test into dir until it ends.
+func testChdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestGlobFiles(t *testing.T) {
+	dir := t.TempDir()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	testFiles(t, dir, "main.go", "README.md", "cmd/root.go", "cmd/sub/deep.go", "cmd/sub/notes.txt", ".hidden.go", ".git/hooks.go", "cmd/.cache/cached.go")
+	testFiles(t, home, "notes/todo.md", "notes/old/done.md", "notes/.trash/gone.md")
+	testChdir(t, dir)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.go", []string{"main.go"}},
+		{"**/*.go", []string{"cmd/root.go", "cmd/sub/deep.go", "main.go"}},
+		{"cmd/**", []string{"cmd/root.go", "cmd/sub/deep.go", "cmd/sub/notes.txt"}},
+		{"cmd/**/*.go", []string{"cmd/root.go", "cmd/sub/deep.go"}},
+		{"cmd/*/*.txt", []string{"cmd/sub/notes.txt"}},
+		{".*.go", []string{".hidden.go"}},
+		{".git/*.go", []string{".git/hooks.go"}},
+		{"cmd/.cache/*", []string{"cmd/.cache/cached.go"}},
+		{"nope/**", []string{}},
+		{"~/notes/**/*.md", []string{home + "/notes/old/done.md", home + "/notes/todo.md"}},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			got, err := globFiles(test.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range got {
+				got[i] = filepath.ToSlash(got[i])
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s matched %v, want %v", test.pattern, got, test.want)
+			}
+		})
+	}
+
+	if _, err := globFiles("cmd/[.go"); err == nil {
+		t.Error("a bad pattern matched without an error")
+	}
+}
+
+func TestMentionedFiles(t *testing.T) {
+	dir := t.TempDir()
+	testFiles(t, dir, "main.go", "cmd/root.go", "v1.2", "README")
+	testChdir(t, dir)
+
+	tests := []struct {
+		prompt string
+		want   []string
+	}{
+		{"look at @main.go.", []string{"main.go"}},
+		{"what do @main.go, @cmd/root.go and @README do?", []string{"main.go", "cmd/root.go", "README"}},
+		{"(see @main.go)", []string{"main.go"}},
+		{"is @v1.2 out?", []string{"v1.2"}},
+		{"@cmd/*.go", []string{"cmd/root.go"}},
+		{"mail me@main.go", []string{}},
+		{"@missing.go and @cmd", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.prompt, func(t *testing.T) {
+			got := mentionedFiles(test.prompt)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%q mentions %v, want %v", test.prompt, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		path    string
+		content string
+		want    string
+	}{
+		{"main.go", "", "go"},
+		{"SCRIPT.PY", "", "python"},
+		{"Makefile", "", "makefile"},
+		{"go.mod", "", "go"},
+		{"deploy", "#!/bin/sh\necho hi", "bash"},
+		{"serve", "#!/usr/bin/env python3\n", "python"},
+		{"app", "#!/usr/bin/env node", "javascript"},
+		{"run", "#!/usr/bin/env -S ruby -w", "ruby"},
+		{"tool", "#!/usr/local/bin/perl\n", "perl"},
+		{"notes", "just text", ""},
+	}
+	for _, test := range tests {
+		if got := detectLanguage(test.path, test.content); got != test.want {
+			t.Errorf("%s is %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestAttachmentBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		fence   string
+	}{
+		{"plain", "fmt.Println()\n", "```"},
+		{"fenced", "```go\nx\n```\n", "````"},
+		{"longer fences", "````\nx\n````\n```\n", "`````"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block := attachment{Path: "main.go", Language: "go", Content: test.content}.Block()
+			want := "### main.go\n\n" + test.fence + "go\n" + strings.TrimRight(test.content, "\n") + "\n" + test.fence
+			if block != want {
+				t.Errorf("block is\n%s\nwant\n%s", block, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// authCmd represents the auth command
+var authCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
+	Use:   "auth",
+	Short: "Manage the api key",
+	Long: `Manage where the api key is kept. The key is looked for in this order:
+
+  1. the YOO_OPENAI_KEY or OPENAI_API_KEY environment variables
+  2. the output of key-command, e.g. key-command: pass show openai
+  3. the system keyring (Secret Service)
+  4. the encrypted secrets file, ~/.config/yoo/secrets.enc
+  5. secrets.openai-key in the config
+
+For example:
+
+  yoo auth login
+  yoo auth status`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(authCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// authCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// authCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
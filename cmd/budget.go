@@ -0,0 +1,162 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// defaultBudgetWarnAt is the fraction of a budget at which to start warning.
+const defaultBudgetWarnAt = 0.8
+
+// budgetPeriods are the budgets that can be set under `budget:`, globally or
+// per persona, along with when each one started counting.
+var budgetPeriods = []struct {
+	name  string
+	start func(now time.Time) time.Time
+}{
+	{"daily", func(now time.Time) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	}},
+	{"monthly", func(now time.Time) time.Time {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	}},
+}
+
+// budgetError is returned when a request would go over a budget.
+type budgetError struct {
+	Scope  string
+	Period string
+	Limit  float64
+	Spent  float64
+	Cost   float64
+}
+
+func (e *budgetError) Error() string {
+	if e.Period == "request" {
+		return fmt.Sprintf("request would cost about $%.4f, over the %s limit of $%g per request (use --force to send it anyway)", e.Cost, e.Scope, e.Limit)
+	}
+	return fmt.Sprintf("request would cost about $%.4f, taking %s spend to $%.4f of its %s budget of $%g (use --force to send it anyway)", e.Cost, e.Scope, e.Spent+e.Cost, e.Period, e.Limit)
+}
+
+// checkBudget refuses a request to persona that would go over the `budget:`
+// set globally or for the persona, unless --force is given. Requests to
+// models without a price are refused too while a budget is set. The request
+// is priced on its estimated prompt tokens, plus the persona's max_tokens
+// since the reply can't be known ahead of time, or the room kept for the reply
+// in the context window when max_tokens isn't set. warn is called for every
+// budget past one of its warn-at fractions.
+func checkBudget(persona Persona, history []openai.ChatCompletionMessage, warn func(string)) error {
+	reply := persona.Params.MaxTokens
+	if reply <= 0 {
+		reply = contextReserve(persona)
+	}
+	return checkSpend(persona.Name, persona.Model, Usage{
+		PromptTokens:     estimateMessagesTokens(persona.Messages(history)),
+		CompletionTokens: reply,
+	}, warn)
+}
+
+// checkSpend is checkBudget for a request made for persona to model, priced
+// on usage, for requests that aren't chat completions.
+func checkSpend(persona string, model string, usage Usage, warn func(string)) error {
+	scopes := []struct {
+		name   string
+		key    string
+		record func(usageRecord) bool
+	}{
+		{"global", "budget", func(usageRecord) bool { return true }},
+		{"persona " + persona, "personas." + persona + ".budget", func(r usageRecord) bool { return r.Persona == persona }},
+	}
+	configured := false
+	for _, scope := range scopes {
+		configured = configured || viper.IsSet(scope.key)
+	}
+	if !configured {
+		return nil
+	}
+	cost, priced := usageCost(model, usage)
+	force := viper.GetBool("force")
+	// a model without a price can't be counted against a budget, so it isn't
+	// let through quietly
+	if !priced && !force {
+		return fmt.Errorf("%s has no price, so the budget can't be kept (set %s, to 0 if it's free, or use --force to send it anyway)", model, joinConfigKey([]string{"models", model, "price"}))
+	}
+	if !priced {
+		return nil
+	}
+
+	now := time.Now().Local()
+	var records []usageRecord
+	for _, scope := range scopes {
+		budget := viper.GetStringMap(scope.key)
+		if len(budget) == 0 {
+			continue
+		}
+		if limit := cast.ToFloat64(budget["request"]); limit > 0 && cost > limit && !force {
+			return &budgetError{Scope: scope.name, Period: "request", Limit: limit, Cost: cost}
+		}
+		for _, period := range budgetPeriods {
+			limit := cast.ToFloat64(budget[period.name])
+			if limit <= 0 {
+				continue
+			}
+			if records == nil {
+				var err error
+				if records, err = readUsage(budgetPeriods[len(budgetPeriods)-1].start(now)); err != nil {
+					return err
+				}
+			}
+			spent := 0.0
+			start := period.start(now)
+			for _, record := range records {
+				if !record.Time.Before(start) && scope.record(record) {
+					spent += record.Cost
+				}
+			}
+			if spent+cost > limit && !force {
+				return &budgetError{Scope: scope.name, Period: period.name, Limit: limit, Spent: spent, Cost: cost}
+			}
+			if fraction := budgetWarning(budget["warn-at"], (spent+cost)/limit); fraction > 0 {
+				warn(fmt.Sprintf("%.0f%% of the %s %s budget of $%g is spent", fraction*100, scope.name, period.name, limit))
+			}
+		}
+	}
+	return nil
+}
+
+// budgetWarning returns used if it has reached any of the warn-at fractions
+// (a number or a list of them), or 0 if not.
+func budgetWarning(warnAt any, used float64) float64 {
+	thresholds := []any{defaultBudgetWarnAt}
+	if list, ok := warnAt.([]any); ok {
+		thresholds = list
+	} else if warnAt != nil {
+		thresholds = []any{warnAt}
+	}
+	for _, threshold := range thresholds {
+		if fraction := cast.ToFloat64(threshold); fraction > 0 && used >= fraction {
+			return used
+		}
+	}
+	return 0
+}
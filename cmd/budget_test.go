@@ -0,0 +1,194 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestUsageLedger(t *testing.T) {
+	testConfig(t, nil)
+	now := time.Now().Local().Truncate(time.Second)
+	records := []usageRecord{
+		{Time: now.Add(-48 * time.Hour), Persona: "archie", Model: "gpt-4", Usage: Usage{PromptTokens: 10, CompletionTokens: 5}, Cost: 0.5},
+		{Time: now.Add(-time.Hour), Persona: "archie", Model: "gpt-4", Provider: "openai", Usage: Usage{PromptTokens: 100, CompletionTokens: 50, Estimated: true}, Cost: 0.25},
+		{Time: now, Persona: "local", Model: "llama3", Provider: "ollama", Usage: Usage{PromptTokens: 7}, Unpriced: true},
+	}
+	if got, err := readUsage(time.Time{}); err != nil || len(got) != 0 {
+		t.Fatalf("read %v (%v) from a missing ledger", got, err)
+	}
+	for _, record := range records {
+		if err := appendUsage(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := readUsage(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		if !got[i].Time.Equal(records[i].Time) {
+			t.Errorf("record %d is from %v, want %v", i, got[i].Time, records[i].Time)
+		}
+		got[i].Time = records[i].Time
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Errorf("read back\n%#v\nwant\n%#v", got, records)
+	}
+	if got, err := readUsage(now.Add(-24 * time.Hour)); err != nil || len(got) != 2 {
+		t.Errorf("read %d records (%v) since yesterday, want 2", len(got), err)
+	}
+}
+
+func TestCheckSpend(t *testing.T) {
+	now := time.Now().Local()
+	monthStart := budgetPeriods[1].start(now)
+	price := map[string]any{"price": map[string]any{"prompt": 1e6, "completion": 0}}
+	// every request costs $1
+	usage := Usage{PromptTokens: 1}
+
+	tests := []struct {
+		name     string
+		settings map[string]any
+		force    bool
+		model    string
+		ledger   []usageRecord
+		// period is the budget gone over, "" when the request is let
+		// through
+		period string
+		scope  string
+		// unpriced is set when the request is refused for having no price
+		unpriced bool
+		warnings int
+	}{
+		{name: "no budget", model: "free"},
+		{name: "unpriced", settings: map[string]any{"budget.daily": 10}, model: "free", unpriced: true},
+		{name: "unpriced with force", settings: map[string]any{"budget.daily": 10}, model: "free", force: true},
+		{name: "under the request limit", settings: map[string]any{"budget.request": 2}},
+		{name: "over the request limit", settings: map[string]any{"budget.request": 0.5}, period: "request", scope: "global"},
+		{name: "over the request limit with force", settings: map[string]any{"budget.request": 0.5}, force: true},
+		{
+			name:     "under the daily budget",
+			settings: map[string]any{"budget.daily": 10},
+			ledger:   []usageRecord{{Time: now, Persona: "archie", Cost: 2}},
+		},
+		{
+			name:     "warned past the default warn-at",
+			settings: map[string]any{"budget.daily": 10},
+			ledger:   []usageRecord{{Time: now, Persona: "archie", Cost: 7}},
+			warnings: 1,
+		},
+		{
+			name:     "warned past one of warn-at",
+			settings: map[string]any{"budget.daily": 10, "budget.warn-at": []any{0.5, 0.95}},
+			ledger:   []usageRecord{{Time: now, Persona: "archie", Cost: 4}},
+			warnings: 1,
+		},
+		{
+			name:     "over the daily budget",
+			settings: map[string]any{"budget.daily": 10},
+			ledger:   []usageRecord{{Time: now, Persona: "archie", Cost: 9.5}},
+			period:   "daily",
+			scope:    "global",
+		},
+		{
+			name:     "over the monthly budget",
+			settings: map[string]any{"budget.monthly": 10},
+			ledger:   []usageRecord{{Time: monthStart, Persona: "archie", Cost: 9.5}},
+			period:   "monthly",
+			scope:    "global",
+		},
+		{
+			name:     "spent last month",
+			settings: map[string]any{"budget.daily": 10, "budget.monthly": 10},
+			ledger:   []usageRecord{{Time: monthStart.Add(-time.Hour), Persona: "archie", Cost: 9.5}},
+		},
+		{
+			name:     "over the persona budget",
+			settings: map[string]any{"personas.archie.budget.daily": 5},
+			ledger:   []usageRecord{{Time: now, Persona: "archie", Cost: 4.5}},
+			period:   "daily",
+			scope:    "persona archie",
+		},
+		{
+			name:     "other personas don't count",
+			settings: map[string]any{"personas.archie.budget.daily": 5},
+			ledger:   []usageRecord{{Time: now, Persona: "other", Cost: 9}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := map[string]any{"models.test": price, "force": test.force}
+			for key, value := range test.settings {
+				settings[key] = value
+			}
+			testConfig(t, settings)
+			for _, record := range test.ledger {
+				if err := appendUsage(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			model := test.model
+			if model == "" {
+				model = "test"
+			}
+
+			warnings := 0
+			err := checkSpend("archie", model, usage, func(string) { warnings++ })
+			var over *budgetError
+			switch {
+			case test.unpriced:
+				if err == nil || errors.As(err, &over) {
+					t.Errorf("sent to an unpriced model: %v", err)
+				}
+			case test.period != "":
+				if !errors.As(err, &over) || over.Period != test.period || over.Scope != test.scope {
+					t.Errorf("got %v, want the %s %s budget to refuse it", err, test.scope, test.period)
+				}
+			case err != nil:
+				t.Errorf("refused: %v", err)
+			}
+			if warnings != test.warnings {
+				t.Errorf("warned %d times, want %d", warnings, test.warnings)
+			}
+		})
+	}
+}
+
+func TestCheckBudgetPricesTheReply(t *testing.T) {
+	testConfig(t, map[string]any{
+		"models.test":    map[string]any{"price": map[string]any{"prompt": 0, "completion": 1e6}},
+		"budget.request": 100,
+	})
+	history := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}
+
+	// without max_tokens, the reply is priced at the room kept for it
+	var over *budgetError
+	if err := checkBudget(Persona{Name: "archie", Model: "test"}, history, func(string) {}); !errors.As(err, &over) || over.Cost != defaultContextReserve {
+		t.Errorf("got %v, want a reply of %d tokens to go over", err, defaultContextReserve)
+	}
+	persona := Persona{Name: "archie", Model: "test", Params: ModelParams{MaxTokens: 50}}
+	if err := checkBudget(persona, history, func(string) {}); err != nil {
+		t.Errorf("refused a reply of 50 tokens: %v", err)
+	}
+}
@@ -18,10 +18,17 @@ package cmd
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	termutil "github.com/andrew-d/go-termutil"
@@ -58,111 +65,317 @@ to quickly create a Cobra application.`,
 			}
 		}
 
-		// set up personas
-		chatPersonaName := viper.GetString("persona")
-		chatSystemPrompt, file, err := loadSystemPrompt(chatPersonaName)
-		checkError(err, "system prompt file could not be read: "+file, true)
-		chatPersona := Persona{
-			chatPersonaName,
-			viper.GetString("personas." + chatPersonaName + ".model"),
-			openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleSystem,
-				Content: chatSystemPrompt,
-			},
-		}
+		// set up personas and providers
+		resources := loadResources()
+
+		newChat(resources, userPrompt)
+	},
+}
+
+// chatSession is the state of a running chat. The log is rewritten after
+// every exchange so an interrupted chat can still be peeped or resumed.
+type chatSession struct {
+	resources LoadedResources
+	history   []openai.ChatCompletionMessage
+	log       ChatLog
+	logName   string
+	// userPrompt is used to title the log
+	userPrompt string
+	// attach holds the files from --file and --glob until they're sent with
+	// the first message
+	attach  attachSpec
+	spinner *spinner.Spinner
+	// summary stands in for the first summarized messages of the history when
+	// the conversation outgrows the context window
+	summary    string
+	summarized int
+
+	mu sync.Mutex
+	// cancel stops the request in flight, and is nil between requests
+	cancel context.CancelFunc
 
-		// print something for ux
-		if !viper.GetBool("quiet") {
-			fmt.Println("chatting with " + chatPersona.Name + "!")
+	// busy is held while a prompt or command runs and while the chat ends,
+	// so ctrl-c waits for them rather than touching the log at the same time
+	busy sync.Mutex
+	// ended is set once the log has been finished
+	ended bool
+}
+
+// runChat runs the chat loop on top of the conversation in chatLog, saving it
+// to logName as it goes. userPrompt is only used to title the log.
+func runChat(resources LoadedResources, chatLog ChatLog, logName string, userPrompt string) {
+	session := &chatSession{
+		resources:  resources,
+		history:    chatLog.History,
+		log:        chatLog,
+		logName:    logName,
+		userPrompt: userPrompt,
+		attach:     attachFlags,
+		spinner:    spinner.New(spinner.CharSets[19], 100*time.Millisecond),
+	}
+	session.spinner.Prefix = "╰─ "
+	checkError(session.persist(), "could not write log", false)
+
+	// ctrl-c stops a reply in progress, or ends the chat between replies
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range interrupts {
+			if session.interrupt() {
+				continue
+			}
+			session.busy.Lock()
+			if session.ended {
+				session.busy.Unlock()
+				return
+			}
+			fmt.Println()
+			session.finish()
+			os.Exit(130)
 		}
+	}()
 
-		// set up an openai client
-		openAIClient := openai.NewClient(viper.GetString("secrets.openai-key"))
-
-		// todo: modularize above
-		// loop
-		history := []openai.ChatCompletionMessage{}
-		reader := bufio.NewReader(os.Stdin)
-		s := spinner.New(spinner.CharSets[19], 100*time.Millisecond)
-		s.Prefix = "╰─ "
-		for {
-			// get prompt
-			fmt.Print("\n≫ ")
-			userPrompt, err := reader.ReadString('\n')
+	// print something for ux
+	if !viper.GetBool("quiet") {
+		fmt.Println("chatting with " + resources.ChatPersona.Name + "! (:help for commands)")
+	}
+
+	// loop
+	for {
+		// get prompt
+		fmt.Print("\n≫ ")
+		input, err := stdinReader.ReadString('\n')
+		if errors.Is(err, io.EOF) && strings.TrimSpace(input) == "" {
+			fmt.Println()
+			break
+		}
+		if !errors.Is(err, io.EOF) {
 			checkError(err, "problem reading stdin", true)
-			userPrompt = strings.TrimSpace(userPrompt)
-			if userPrompt == "quit" || userPrompt == "exit" {
-				fmt.Println("chat ended!")
+		}
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+
+		session.busy.Lock()
+		if strings.HasPrefix(input, ":") {
+			done, err := session.command(input)
+			session.busy.Unlock()
+			checkError(err, input+" failed", false)
+			if done {
 				break
 			}
+			continue
+		}
+
+		err = session.send(input)
+		session.busy.Unlock()
+		checkError(err, "could not complete request to "+session.resources.ChatPersona.Name, false)
+	}
+
+	signal.Stop(interrupts)
+	session.busy.Lock()
+	defer session.busy.Unlock()
+	session.finish()
+}
+
+// send attaches files to a typed prompt and sends it.
+func (c *chatSession) send(userPrompt string) error {
+	if c.userPrompt == "" {
+		c.userPrompt = userPrompt
+	}
+	userPrompt, err := attachFiles(c.resources.ChatPersona, userPrompt, userPrompt, c.attach, c.notice)
+	if errors.Is(err, errNotSent) {
+		fmt.Println("(not sent)")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.attach = attachSpec{}
+	return c.complete(userPrompt)
+}
 
-			// get the prompt response
-			s.Color("cyan")
-			s.Start()
-			promptResponse, err := createChatCompletion(
-				openAIClient,
-				chatPersona,
-				userPrompt,
-				history)
-			checkError(err, "could not complete request to openai", true)
-			s.Stop()
-
-			// write response out to console
-			fmt.Println("╰─ " + promptResponse)
-
-			// add the pair of messages to the history
-			history = append(
-				history,
-				openai.ChatCompletionMessage{
-					Role:    openai.ChatMessageRoleUser,
-					Content: userPrompt,
-				},
-				openai.ChatCompletionMessage{
-					Role:    openai.ChatMessageRoleAssistant,
-					Content: promptResponse,
-				},
-			)
+// complete streams the reply to a prompt that has its files attached already
+// and adds the exchange to the history. An interrupted reply is kept as far
+// as it got.
+func (c *chatSession) complete(userPrompt string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.mu.Lock()
+	c.cancel = cancel
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.cancel = nil
+		c.mu.Unlock()
+	}()
+
+	c.spinner.Color("cyan")
+	c.spinner.Start()
+	// recalled answers and knowledge are sent along but not logged
+	persona := withRecall(ctx, c.resources.ChatPersona, userPrompt, c.logName, c.notice)
+	persona = withKnowledge(ctx, persona, userPrompt, c.notice)
+	history := c.contextHistory(ctx, persona, userPrompt)
+	promptResponse, usage, err := streamChatCompletion(
+		ctx,
+		c.resources.ChatProvider,
+		persona,
+		userPrompt,
+		history,
+		c.spinner,
+		"╰─ ")
+	if ctx.Err() != nil {
+		fmt.Println("(interrupted)")
+		if promptResponse == "" {
+			return nil
 		}
-		// todo: modularize below
-
-		// set up title persona
-		titlePersonaName := viper.GetString("title-persona")
-		titleSystemPrompt, file, err := loadSystemPrompt(titlePersonaName)
-		checkError(err, "system prompt file could not be read: "+file, true)
-		titlePersona := Persona{
-			titlePersonaName,
-			viper.GetString("personas." + titlePersonaName + ".model"),
-			openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleSystem,
-				Content: titleSystemPrompt,
-			},
+	} else if err != nil {
+		return err
+	}
+
+	// add the pair of messages to the history
+	c.log.Meta.Usage = c.log.Meta.Usage.Add(usage)
+	c.history = append(
+		c.history,
+		openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: userPrompt,
+		},
+		openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleAssistant,
+			Content: promptResponse,
+		},
+	)
+	return c.persist()
+}
+
+// notice prints a note about the session above the reply.
+func (c *chatSession) notice(message string) {
+	if viper.GetBool("quiet") {
+		return
+	}
+	restart := c.spinner.Active()
+	if restart {
+		c.spinner.Stop()
+	}
+	fmt.Println("(" + message + ")")
+	if restart {
+		c.spinner.Start()
+	}
+}
+
+// interrupt cancels the request in flight, reporting whether there was one.
+func (c *chatSession) interrupt() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel == nil {
+		return false
+	}
+	c.cancel()
+	return true
+}
+
+// persist writes the conversation so far to the log.
+func (c *chatSession) persist() error {
+	c.log.History = c.history
+	return writeChatLog(c.logName, c.log)
+}
+
+// setTitle names the log, renaming its file to match.
+func (c *chatSession) setTitle(title string) error {
+	c.log.Meta.Title = title
+	logName := filepath.Join(filepath.Dir(c.logName), c.log.FileName())
+	if logName != c.logName {
+		if err := os.Rename(c.logName, logName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
 		}
+		c.logName = logName
+	}
+	return c.persist()
+}
 
-		// get title content
-		titleContent := div("system") + chatPersona.SystemMessage.Content + div("prompt") + userPrompt
-		title, err := createChatCompletion(
-			openAIClient,
-			titlePersona,
-			titleContent,
-			[]openai.ChatCompletionMessage{})
-		checkError(err, "could not complete request to openai for title slug", false)
-		if title == "" {
-			title = "unknown-topic"
+// save titles the log if it hasn't been yet and writes it, returning the log's
+// path. Empty conversations aren't saved.
+func (c *chatSession) save() (string, error) {
+	if len(c.history) == 0 {
+		return "", nil
+	}
+	if c.log.Meta.Title == "" {
+		userPrompt := c.userPrompt
+		if userPrompt == "" {
+			userPrompt = c.history[0].Content
 		}
+		if err := c.setTitle(generateTitle(c.resources, userPrompt)); err != nil {
+			return "", err
+		}
+	}
+	return c.logName, c.persist()
+}
+
+// finish saves the log at the end of the chat, or removes it if nothing was
+// said.
+func (c *chatSession) finish() {
+	c.ended = true
+	if len(c.history) == 0 {
+		os.Remove(c.logName)
+	} else {
+		_, err := c.save()
+		checkError(err, "could not write log", false)
+		// the index is only a cache, a search or `yoo index rebuild` catches
+		// up if this fails
+		indexLog(c.logName)
+	}
+	fmt.Println("chat ended!")
+}
+
+// newChat starts a chat with the chat persona in a new log.
+func newChat(resources LoadedResources, userPrompt string) {
+	chatLog := newChatLog(resources.ChatPersona)
+	runChat(resources, chatLog, resources.LogDir+chatLog.FileName(), userPrompt)
+}
 
-		// log response to file
-		currentTime := time.Now().Local().Format("2006-01-02--15-04-05-MST")
-		logName := viper.GetString("logpath") + currentTime + "." + title + ".md"
-		metaContent := "# " + title + "\n\n" + currentTime
+// resumeChat drops back into the chat loop with the conversation from a log,
+// using the persona recorded in it when there is one. The conversation carries
+// on in the same log, unless it's from before logs were versioned.
+func resumeChat(path string) {
+	chatLog, err := readChatLog(path)
+	checkError(err, "could not read log "+path, true)
 
-		chatHistoryContent := ""
-		for _, message := range history {
-			chatHistoryContent += string(message.Role) + ":\n" + message.Content + "\n\n"
+	if persona := chatLog.Meta.Persona; persona != "" {
+		if personaExists(persona) {
+			viper.Set("persona", persona)
+		} else if !viper.GetBool("quiet") {
+			fmt.Println("persona " + persona + " no longer exists, using " + viper.GetString("persona"))
 		}
+	}
+	resources := loadResources()
 
-		content := metaContent + div("chat conversation") + chatHistoryContent + div("system") + chatPersona.SystemMessage.Content
-		os.WriteFile(logName, []byte(content), 0644)
-	},
+	// title the resumed log after its first prompt, and show where we left off
+	userPrompt := ""
+	for _, message := range chatLog.History {
+		if message.Role == openai.ChatMessageRoleUser {
+			userPrompt = message.Content
+			break
+		}
+	}
+	if !viper.GetBool("quiet") {
+		fmt.Println("resuming " + filepath.Base(path))
+		if n := len(chatLog.History); n >= 2 {
+			fmt.Println("\n≫ " + chatLog.History[n-2].Content)
+			fmt.Println("╰─ " + chatLog.History[n-1].Content)
+		}
+	}
+
+	if chatLog.Meta.Version == 0 {
+		history := chatLog.History
+		chatLog = newChatLog(resources.ChatPersona)
+		chatLog.History = history
+		path = resources.LogDir + chatLog.FileName()
+	} else {
+		chatLog.setPersona(resources.ChatPersona)
+	}
+	runChat(resources, chatLog, path, userPrompt)
 }
 
 func init() {
@@ -175,6 +388,9 @@ func init() {
 	chatCmd.PersistentFlags().String("persona", "", "the persona to use for this call")
 	viper.BindPFlag("persona", chatCmd.PersistentFlags().Lookup("persona"))
 
+	chatCmd.PersistentFlags().StringArrayVarP(&attachFlags.files, "file", "f", []string{}, "file to add to the first message (repeatable)")
+	chatCmd.PersistentFlags().StringArrayVar(&attachFlags.globs, "glob", []string{}, "files to add to the first message, ** matches any directories (repeatable)")
+
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// chatCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
@@ -0,0 +1,225 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// chatCommand is a `:`-prefixed command inside chat. run returns true when
+// the chat should end.
+type chatCommand struct {
+	usage string
+	help  string
+	run   func(c *chatSession, arg string) (bool, error)
+}
+
+var chatCommands = map[string]chatCommand{}
+
+// command runs a `:name arg` line typed into chat.
+func (c *chatSession) command(input string) (bool, error) {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(input, ":"), " ")
+	command, ok := chatCommands[name]
+	if !ok {
+		return false, errors.New("unknown command :" + name + ", see :help")
+	}
+	return command.run(c, strings.TrimSpace(arg))
+}
+
+// lastReply is the most recent assistant message, if any.
+func (c *chatSession) lastReply() (string, bool) {
+	for i := len(c.history) - 1; i >= 0; i-- {
+		if c.history[i].Role == openai.ChatMessageRoleAssistant {
+			return c.history[i].Content, true
+		}
+	}
+	return "", false
+}
+
+// biggestCodeBlock returns the contents of the largest fenced code block in
+// markdown.
+func biggestCodeBlock(markdown string) (string, bool) {
+	biggest, found := "", false
+	var fence string
+	var block []string
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence == "" {
+			if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+				fence = trimmed[:3]
+				block = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			if content := strings.Join(block, "\n"); !found || len(content) > len(biggest) {
+				biggest, found = content, true
+			}
+			fence = ""
+			continue
+		}
+		block = append(block, line)
+	}
+	return biggest, found
+}
+
+func init() {
+	quit := chatCommand{":quit", "save the conversation and end the chat", func(c *chatSession, arg string) (bool, error) {
+		return true, nil
+	}}
+	chatCommands["quit"] = quit
+	chatCommands["q"] = quit
+	chatCommands["exit"] = quit
+
+	chatCommands["help"] = chatCommand{":help", "show this help", func(c *chatSession, arg string) (bool, error) {
+		usages := map[string]string{}
+		for _, command := range chatCommands {
+			usages[command.usage] = command.help
+		}
+		lines := []string{}
+		for usage, help := range usages {
+			lines = append(lines, fmt.Sprintf("  %-16s %s", usage, help))
+		}
+		sort.Strings(lines)
+		fmt.Println(strings.Join(lines, "\n"))
+		return false, nil
+	}}
+
+	chatCommands["save"] = chatCommand{":save", "write the conversation so far to its log", func(c *chatSession, arg string) (bool, error) {
+		logName, err := c.save()
+		if err != nil {
+			return false, err
+		}
+		if logName == "" {
+			fmt.Println("nothing to save yet")
+		} else {
+			fmt.Println("saved to " + logName)
+		}
+		return false, nil
+	}}
+
+	chatCommands["title"] = chatCommand{":title [title]", "name the log now, generating a title if none is given", func(c *chatSession, arg string) (bool, error) {
+		if len(c.history) == 0 && arg == "" {
+			return false, errors.New("nothing to title yet")
+		}
+		title := arg
+		if title == "" {
+			userPrompt := c.userPrompt
+			if userPrompt == "" {
+				userPrompt = c.history[0].Content
+			}
+			title = generateTitle(c.resources, userPrompt)
+		} else {
+			title = slugTitle(title)
+		}
+		if err := c.setTitle(title); err != nil {
+			return false, err
+		}
+		fmt.Println("saved to " + c.logName)
+		return false, nil
+	}}
+
+	chatCommands["reset"] = chatCommand{":reset", "save the conversation and start a new one", func(c *chatSession, arg string) (bool, error) {
+		logName, err := c.save()
+		if err != nil {
+			return false, err
+		}
+		if logName != "" {
+			fmt.Println("saved to " + logName)
+			// the finished log is indexed like one at the end of the chat
+			indexLog(logName)
+		}
+		c.history = []openai.ChatCompletionMessage{}
+		c.log = newChatLog(c.resources.ChatPersona)
+		c.logName = c.resources.LogDir + c.log.FileName()
+		c.userPrompt = ""
+		if err := c.persist(); err != nil {
+			return false, err
+		}
+		fmt.Println("new conversation with " + c.resources.ChatPersona.Name + "!")
+		return false, nil
+	}}
+
+	chatCommands["undo"] = chatCommand{":undo", "forget the last prompt and reply", func(c *chatSession, arg string) (bool, error) {
+		if len(c.history) < 2 {
+			return false, errors.New("nothing to undo")
+		}
+		c.history = c.history[:len(c.history)-2]
+		fmt.Println("forgot the last exchange")
+		return false, c.persist()
+	}}
+
+	chatCommands["retry"] = chatCommand{":retry", "ask for a new reply to the last prompt", func(c *chatSession, arg string) (bool, error) {
+		if len(c.history) < 2 {
+			return false, errors.New("nothing to retry")
+		}
+		// the prompt is sent as it was, its files already attached
+		history := c.history
+		c.history = history[:len(history)-2]
+		if err := c.complete(history[len(history)-2].Content); err != nil {
+			c.history = history
+			return false, err
+		}
+		return false, nil
+	}}
+
+	chatCommands["persona"] = chatCommand{":persona <name>", "switch to another persona, keeping the conversation", func(c *chatSession, arg string) (bool, error) {
+		if arg == "" {
+			fmt.Println(c.resources.ChatPersona.Name)
+			return false, nil
+		}
+		persona, err := loadPersona(arg)
+		if err != nil {
+			return false, err
+		}
+		provider, err := providerFor(persona)
+		if err != nil {
+			return false, err
+		}
+		c.resources.ChatPersona = persona
+		c.resources.ChatProvider = provider
+		c.log.setPersona(persona)
+		fmt.Println("now chatting with " + persona.Name + "!")
+		return false, c.persist()
+	}}
+
+	chatCommands["code"] = chatCommand{":code <file>", "write the biggest code block of the last reply to a file", func(c *chatSession, arg string) (bool, error) {
+		reply, ok := c.lastReply()
+		if !ok {
+			return false, errors.New("no reply yet")
+		}
+		code, ok := biggestCodeBlock(reply)
+		if !ok {
+			return false, errors.New("the last reply has no code block")
+		}
+		if arg == "" {
+			fmt.Println(code)
+			return false, nil
+		}
+		if err := os.WriteFile(arg, []byte(code+"\n"), 0644); err != nil {
+			return false, err
+		}
+		fmt.Printf("wrote %d lines to %s\n", strings.Count(code, "\n")+1, arg)
+		return false, nil
+	}}
+}
@@ -0,0 +1,355 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// logFormatVersion is written to the front matter of every log as `yoo-log`.
+// Logs without it are from before the format was versioned.
+const logFormatVersion = 1
+
+// logTimeFormat is used in log file names and legacy log headers.
+const logTimeFormat = "2006-01-02--15-04-05-MST"
+
+// LogMeta is the yaml front matter of a log file.
+type LogMeta struct {
+	Version  int       `yaml:"yoo-log"`
+	Title    string    `yaml:"title"`
+	Persona  string    `yaml:"persona,omitempty"`
+	Model    string    `yaml:"model,omitempty"`
+	Provider string    `yaml:"provider,omitempty"`
+	Started  time.Time `yaml:"started"`
+	Updated  time.Time `yaml:"updated"`
+	Usage    Usage     `yaml:"usage,omitempty"`
+	Tags     []string  `yaml:"tags,omitempty"`
+}
+
+// ChatLog is a conversation as written to and read back from a log file.
+type ChatLog struct {
+	Meta   LogMeta
+	System string
+	// Examples are the persona's examples, only kept when `log-examples` is
+	// set.
+	Examples []openai.ChatCompletionMessage
+	History  []openai.ChatCompletionMessage
+}
+
+// messageMarker starts every message in a log. The line count lets message
+// bodies contain anything, including other markers.
+var messageMarker = regexp.MustCompile(`^<!-- yoo:(message|example) role=([a-z]+) lines=([0-9]+) -->$`)
+
+// newChatLog starts a log for a conversation with persona.
+func newChatLog(persona Persona) ChatLog {
+	now := time.Now().Local().Truncate(time.Second)
+	l := ChatLog{
+		Meta: LogMeta{
+			Version: logFormatVersion,
+			Started: now,
+			Updated: now,
+			Tags:    viper.GetStringSlice("tags"),
+		},
+		History: []openai.ChatCompletionMessage{},
+	}
+	l.setPersona(persona)
+	return l
+}
+
+// setPersona records the persona the conversation is held with.
+func (l *ChatLog) setPersona(persona Persona) {
+	l.Meta.Persona = persona.Name
+	l.Meta.Model = persona.Model
+	l.Meta.Provider = persona.Provider
+	l.System = persona.SystemMessage.Content
+	l.Examples = nil
+	if personaSetting(persona, "log-examples") == "true" {
+		l.Examples = persona.Examples
+	}
+}
+
+// Marshal renders the log as markdown with yaml front matter.
+func (l ChatLog) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(l.Meta); err != nil {
+		return nil, err
+	}
+	buf.WriteString("---\n\n# " + l.Meta.Title + "\n")
+	for _, message := range l.Examples {
+		writeLogMessage(&buf, "example", message.Role, message.Content)
+	}
+	for _, message := range l.History {
+		writeLogMessage(&buf, "message", message.Role, message.Content)
+	}
+	writeLogMessage(&buf, "message", openai.ChatMessageRoleSystem, l.System)
+	return buf.Bytes(), nil
+}
+
+// writeLogMessage writes a message, or an example when kind is "example".
+func writeLogMessage(buf *bytes.Buffer, kind string, role string, content string) {
+	lines := 0
+	if content != "" {
+		lines = strings.Count(content, "\n") + 1
+	}
+	heading := role
+	if kind == "example" {
+		heading = "example " + role
+	}
+	fmt.Fprintf(buf, "\n## %s\n\n<!-- yoo:%s role=%s lines=%d -->\n", heading, kind, role, lines)
+	if lines > 0 {
+		buf.WriteString(content + "\n")
+	}
+}
+
+// FileName is the name the log is saved under in the log directory.
+func (l ChatLog) FileName() string {
+	title := l.Meta.Title
+	if title == "" {
+		title = "untitled"
+	}
+	return l.Meta.Started.Format(logTimeFormat) + "." + title + ".md"
+}
+
+// writeChatLog stamps the log as updated and writes it to path. The file is
+// replaced atomically so a crash never leaves half a log behind.
+func writeChatLog(path string, l ChatLog) error {
+	l.Meta.Updated = time.Now().Local().Truncate(time.Second)
+	content, err := l.Marshal()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".yoo-log-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readChatLog reads a log file of any format.
+func readChatLog(path string) (ChatLog, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ChatLog{}, err
+	}
+	return parseChatLog(string(content))
+}
+
+// parseChatLog reads a conversation back out of a log, falling back to the
+// unversioned format for older logs.
+func parseChatLog(content string) (ChatLog, error) {
+	if !strings.HasPrefix(content, "---\n") {
+		return parseLegacyChatLog(content)
+	}
+	end := strings.Index(content[len("---\n"):], "\n---\n")
+	if end == -1 {
+		return ChatLog{}, errors.New("front matter is not closed")
+	}
+	end += len("---\n")
+
+	l := ChatLog{History: []openai.ChatCompletionMessage{}}
+	if err := yaml.Unmarshal([]byte(content[len("---\n"):end]), &l.Meta); err != nil {
+		return ChatLog{}, fmt.Errorf("could not parse front matter: %w", err)
+	}
+	if l.Meta.Version > logFormatVersion {
+		return ChatLog{}, fmt.Errorf("log format %d is newer than this yoo understands", l.Meta.Version)
+	}
+
+	lines := strings.Split(content[end+len("\n---\n"):], "\n")
+	for i := 0; i < len(lines); i++ {
+		match := messageMarker.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
+		kind, role := match[1], match[2]
+		count, _ := strconv.Atoi(match[3])
+		if i+1+count > len(lines) {
+			return ChatLog{}, fmt.Errorf("message on line %d is truncated", i+1)
+		}
+		message := strings.Join(lines[i+1:i+1+count], "\n")
+		if kind == "example" {
+			l.Examples = append(l.Examples, openai.ChatCompletionMessage{Role: role, Content: message})
+		} else if role == openai.ChatMessageRoleSystem {
+			l.System = message
+		} else {
+			l.History = append(l.History, openai.ChatCompletionMessage{Role: role, Content: message})
+		}
+		i += count
+	}
+	return l, nil
+}
+
+// parseLegacyChatLog reads logs written before the versioned format.
+func parseLegacyChatLog(content string) (ChatLog, error) {
+	end := strings.LastIndex(content, div("system"))
+	if end == -1 {
+		return ChatLog{}, errors.New("no system section found")
+	}
+	body := content[:end]
+	l := ChatLog{System: content[end+len(div("system")):]}
+
+	// the header is "# title\n\ntimestamp"
+	header, _, _ := strings.Cut(body, "\n\n## ")
+	if title, rest, ok := strings.Cut(strings.TrimPrefix(header, "# "), "\n\n"); ok {
+		l.Meta.Title = title
+		if started, err := time.Parse(logTimeFormat, strings.TrimSpace(rest)); err == nil {
+			l.Meta.Started = started
+			l.Meta.Updated = started
+		}
+	}
+
+	// logs written by chat
+	if start := strings.Index(body, div("chat conversation")); start != -1 {
+		body = body[start+len(div("chat conversation")):]
+		if i := strings.LastIndex(body, div("persona")); i != -1 {
+			l.Meta.Persona = strings.TrimSpace(body[i+len(div("persona")):])
+			body = body[:i]
+		}
+		l.History = parseChatMessages(body)
+		return l, nil
+	}
+
+	// logs written by uh hold a single prompt, then the response under a
+	// heading named after the persona
+	start := strings.Index(body, div("user"))
+	if start == -1 {
+		return ChatLog{}, errors.New("no conversation found")
+	}
+	body = body[start+len(div("user")):]
+	prompt, name, response, ok := cutPersonaDiv(body)
+	if !ok {
+		return ChatLog{}, errors.New("no response found")
+	}
+	l.Meta.Persona = name
+	l.History = []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+		{Role: openai.ChatMessageRoleAssistant, Content: response},
+	}
+	return l, nil
+}
+
+// parseChatMessages splits "role:\ncontent\n\n" entries.
+func parseChatMessages(body string) []openai.ChatCompletionMessage {
+	history := []openai.ChatCompletionMessage{}
+	var current *openai.ChatCompletionMessage
+	var lines []string
+	flush := func() {
+		if current != nil {
+			current.Content = strings.TrimSuffix(strings.Join(lines, "\n"), "\n")
+			history = append(history, *current)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n\n"), "\n") {
+		switch line {
+		case openai.ChatMessageRoleUser + ":", openai.ChatMessageRoleAssistant + ":", openai.ChatMessageRoleSystem + ":":
+			flush()
+			current = &openai.ChatCompletionMessage{Role: strings.TrimSuffix(line, ":")}
+			lines = nil
+		default:
+			lines = append(lines, line)
+		}
+	}
+	flush()
+	return history
+}
+
+// cutPersonaDiv splits a uh log body at the heading that names the persona.
+// Responses often contain headings of their own, so headings naming a known
+// persona are preferred.
+func cutPersonaDiv(body string) (string, string, string, bool) {
+	type candidate struct {
+		at   int
+		name string
+	}
+	candidates := []candidate{}
+	for offset := 0; ; {
+		i := strings.Index(body[offset:], "\n\n## ")
+		if i == -1 {
+			break
+		}
+		at := offset + i
+		line, _, _ := strings.Cut(body[at+len("\n\n## "):], "\n")
+		if line != "" && !strings.ContainsAny(line, " \t") && strings.HasPrefix(body[at+len("\n\n## ")+len(line):], "\n\n") {
+			candidates = append(candidates, candidate{at, line})
+		}
+		offset = at + 1
+	}
+	if len(candidates) == 0 {
+		return "", "", "", false
+	}
+	chosen := candidates[0]
+	for _, c := range candidates {
+		if personaExists(c.name) {
+			chosen = c
+			break
+		}
+	}
+	return body[:chosen.at], chosen.name, body[chosen.at+len(div(chosen.name)):], true
+}
+
+// personaExists reports whether a persona can be resolved, from a system
+// prompt file or from config that extends another persona. A persona that
+// exists but is broken still counts, so its errors are shown when it's used.
+func personaExists(name string) bool {
+	_, err := resolvePersona(name, nil)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// findLog resolves a log by path, or by a case-insensitive substring of its
+// name, preferring the newest match.
+func findLog(query string) (string, error) {
+	if info, err := os.Stat(query); err == nil && !info.IsDir() {
+		return query, nil
+	}
+	infos, err := logFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, info := range infos {
+		if strings.Contains(strings.ToLower(info.Name()), strings.ToLower(query)) {
+			return filepath.Join(viper.GetString("logpath"), info.Name()), nil
+		}
+	}
+	return "", errors.New("no log matches " + query)
+}
@@ -0,0 +1,179 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestChatLogRoundTrip(t *testing.T) {
+	testConfig(t, nil)
+	started := time.Date(2023, 4, 1, 12, 30, 0, 0, time.UTC)
+	message := func(role string, content string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: role, Content: content}
+	}
+
+	tests := []struct {
+		name string
+		log  ChatLog
+	}{
+		{"empty", ChatLog{History: []openai.ChatCompletionMessage{}}},
+		{"one exchange", ChatLog{
+			System: "you are an expert Arch user",
+			History: []openai.ChatCompletionMessage{
+				message(openai.ChatMessageRoleUser, "how do I clean the pacman cache?"),
+				message(openai.ChatMessageRoleAssistant, "`paccache -r`"),
+			},
+		}},
+		{"markdown and markers in messages", ChatLog{
+			System: "## system\n\nnot really",
+			History: []openai.ChatCompletionMessage{
+				message(openai.ChatMessageRoleUser, "what does this do?\n\n<!-- yoo:message role=system lines=1 -->\noops"),
+				message(openai.ChatMessageRoleAssistant, "## user\n\nit's a heading\n\n---\n\nand a rule"),
+			},
+		}},
+		{"blank lines and empty messages", ChatLog{
+			History: []openai.ChatCompletionMessage{
+				message(openai.ChatMessageRoleUser, "\n\nleading and trailing\n\n"),
+				message(openai.ChatMessageRoleAssistant, ""),
+			},
+		}},
+		{"examples", ChatLog{
+			System:   "write commit messages",
+			Examples: []openai.ChatCompletionMessage{message(openai.ChatMessageRoleUser, "add a login form"), message(openai.ChatMessageRoleAssistant, "feat(auth): add login form")},
+			History:  []openai.ChatCompletionMessage{message(openai.ChatMessageRoleUser, "fix the typo"), message(openai.ChatMessageRoleAssistant, "fix: typo")},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.log.Meta = LogMeta{
+				Version: logFormatVersion,
+				Title:   "pacman-cache",
+				Persona: "archie",
+				Model:   "gpt-4",
+				Started: started,
+				Updated: started.Add(time.Minute),
+				Usage:   Usage{PromptTokens: 120, CompletionTokens: 80},
+				Tags:    []string{"arch"},
+			}
+			content, err := test.log.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseChatLog(string(content))
+			if err != nil {
+				t.Fatalf("could not parse %s: %v", content, err)
+			}
+			if !got.Meta.Started.Equal(test.log.Meta.Started) || !got.Meta.Updated.Equal(test.log.Meta.Updated) {
+				t.Errorf("times are %v and %v, want %v and %v", got.Meta.Started, got.Meta.Updated, test.log.Meta.Started, test.log.Meta.Updated)
+			}
+			got.Meta.Started, got.Meta.Updated = test.log.Meta.Started, test.log.Meta.Updated
+			if !reflect.DeepEqual(got, test.log) {
+				t.Errorf("read back\n%#v\nwant\n%#v", got, test.log)
+			}
+		})
+	}
+}
+
+func TestParseLegacyChatLog(t *testing.T) {
+	dir := testConfig(t, nil)
+	if err := os.WriteFile(dir+"archie.md", []byte("you are an expert Arch user"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2023, 4, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		content string
+		persona string
+		history []openai.ChatCompletionMessage
+		system  string
+	}{
+		{
+			name:    "uh",
+			content: "# pacman-cache\n\n2023-04-01--12-30-00-UTC" + div("user") + "how do I clean the pacman cache?" + div("archie") + "`paccache -r`" + div("system") + "you are an expert Arch user",
+			persona: "archie",
+			history: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: "how do I clean the pacman cache?"},
+				{Role: openai.ChatMessageRoleAssistant, Content: "`paccache -r`"},
+			},
+			system: "you are an expert Arch user",
+		},
+		{
+			name:    "uh with headings in the prompt",
+			content: "# pacman-cache\n\n2023-04-01--12-30-00-UTC" + div("user") + "explain this" + div("notes") + "keep it short" + div("archie") + "ok" + div("system") + "you are an expert Arch user",
+			persona: "archie",
+			history: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: "explain this" + div("notes") + "keep it short"},
+				{Role: openai.ChatMessageRoleAssistant, Content: "ok"},
+			},
+			system: "you are an expert Arch user",
+		},
+		{
+			name:    "chat",
+			content: "# pacman-cache\n\n2023-04-01--12-30-00-UTC" + div("chat conversation") + "user:\nhi\n\nassistant:\nhello\nthere\n\nuser:\nbye\n\nassistant:\nbye!\n\n" + div("system") + "you are an expert Arch user",
+			history: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: "hi"},
+				{Role: openai.ChatMessageRoleAssistant, Content: "hello\nthere"},
+				{Role: openai.ChatMessageRoleUser, Content: "bye"},
+				{Role: openai.ChatMessageRoleAssistant, Content: "bye!"},
+			},
+			system: "you are an expert Arch user",
+		},
+		{
+			name:    "chat with persona",
+			content: "# pacman-cache\n\n2023-04-01--12-30-00-UTC" + div("chat conversation") + "user:\nhi\n\nassistant:\nhello\n\n" + div("persona") + "archie" + div("system") + "you are an expert Arch user",
+			persona: "archie",
+			history: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: "hi"},
+				{Role: openai.ChatMessageRoleAssistant, Content: "hello"},
+			},
+			system: "you are an expert Arch user",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseChatLog(test.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Meta.Title != "pacman-cache" || !got.Meta.Started.Equal(started) {
+				t.Errorf("title and start are %q and %v", got.Meta.Title, got.Meta.Started)
+			}
+			if got.Meta.Persona != test.persona {
+				t.Errorf("persona is %q, want %q", got.Meta.Persona, test.persona)
+			}
+			if !reflect.DeepEqual(got.History, test.history) {
+				t.Errorf("history is\n%#v\nwant\n%#v", got.History, test.history)
+			}
+			if got.System != test.system {
+				t.Errorf("system is %q, want %q", got.System, test.system)
+			}
+		})
+	}
+
+	for _, content := range []string{"", "# untitled\n\njust some notes", "# untitled" + div("system") + "no conversation"} {
+		if _, err := parseChatLog(content); err == nil {
+			t.Errorf("parsed %q without an error", content)
+		}
+	}
+}
@@ -20,23 +20,37 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // configCmd represents the config command
 var configCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
 	Use:   "config",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Short: "Read and change the config",
+	Long: `Read and change ~/.config/yoo/config.yml. Comments and formatting in the file
+are kept when yoo changes it. For example:
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+  yoo config --persona archie
+  yoo config set personas.archie.model gpt-4
+  yoo config list`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("config called")
+		if configPersona == "" {
+			cmd.Help()
+			return
+		}
+		if !personaExists(configPersona) {
+			checkError(fmt.Errorf("persona %s doesn't exist", configPersona), "create it with `yoo config persona "+configPersona+"`", true)
+		}
+		checkError(setConfigValue([]string{"persona"}, configPersona), "could not set default persona", true)
+		if !viper.GetBool("quiet") {
+			fmt.Println("default persona is now " + configPersona)
+		}
 	},
 }
 
+var configPersona string
+
 func init() {
 	rootCmd.AddCommand(configCmd)
 
@@ -48,5 +62,5 @@ func init() {
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
-	// configCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	configCmd.Flags().StringVar(&configPersona, "persona", "", "set the default persona")
 }
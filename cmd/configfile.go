@@ -0,0 +1,281 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// configFilePath is the config file in use, or where to create one.
+func configFilePath() string {
+	if path := viper.ConfigFileUsed(); path != "" {
+		return path
+	}
+	return viper.GetString("configpath") + "config.yml"
+}
+
+// setConfigValue sets a key (split into its path, since persona and model
+// names may contain dots) in the config file and reloads it. The file is
+// edited as a yaml tree rather than written out by viper, so comments and
+// keys yoo doesn't know about are kept.
+func setConfigValue(keys []string, value any) error {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return err
+	}
+	return setConfigNode(keys, &node)
+}
+
+// setConfigNode is setConfigValue for a value that is already yaml.
+func setConfigNode(keys []string, node *yaml.Node) error {
+	return editConfigFile(func(root *yaml.Node) error {
+		return setYAMLValue(root, keys, node)
+	})
+}
+
+// unsetConfigValue removes a key from the config file and reloads it. It
+// reports whether the key was there.
+func unsetConfigValue(keys []string) (bool, error) {
+	found := false
+	err := editConfigFile(func(root *yaml.Node) error {
+		found = removeYAMLValue(root, keys)
+		return nil
+	})
+	return found, err
+}
+
+func editConfigFile(edit func(root *yaml.Node) error) error {
+	path := configFilePath()
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if err := edit(doc.Content[0]); err != nil {
+		return err
+	}
+
+	encoded, err := encodeYAML(&doc)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, encoded, 0644); err != nil {
+		return err
+	}
+	viper.SetConfigFile(path)
+	return readConfig()
+}
+
+// encodeYAML writes yaml the way yoo's own files are written, indented by two.
+func encodeYAML(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setYAMLValue sets keys under a mapping node, creating mappings on the way.
+func setYAMLValue(node *yaml.Node, keys []string, value *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d is not a mapping", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != keys[0] {
+			continue
+		}
+		if len(keys) > 1 && node.Content[i+1].Tag == "!!null" {
+			// an empty section, like `personas:` with nothing under it
+			node.Content[i+1] = &yaml.Node{Kind: yaml.MappingNode}
+		}
+		if len(keys) == 1 {
+			// keep the comments of the value being replaced
+			value.HeadComment = node.Content[i+1].HeadComment
+			value.LineComment = node.Content[i+1].LineComment
+			node.Content[i+1] = value
+			return nil
+		}
+		return setYAMLValue(node.Content[i+1], keys[1:], value)
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keys[0]}
+	for _, k := range keys[1:] {
+		mapping := &yaml.Node{Kind: yaml.MappingNode}
+		node.Content = append(node.Content, key, mapping)
+		node, key = mapping, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}
+	}
+	node.Content = append(node.Content, key, value)
+	return nil
+}
+
+// removeYAMLValue removes keys from under a mapping node.
+func removeYAMLValue(node *yaml.Node, keys []string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != keys[0] {
+			continue
+		}
+		if len(keys) == 1 {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return true
+		}
+		return removeYAMLValue(node.Content[i+1], keys[1:])
+	}
+	return false
+}
+
+// splitConfigKey splits a dotted key like `personas.archie.model`. Segments
+// holding dots of their own are quoted: `models."gpt-3.5-turbo".price`.
+func splitConfigKey(key string) ([]string, error) {
+	keys := []string{}
+	for key != "" {
+		var segment string
+		if rest, ok := strings.CutPrefix(key, `"`); ok {
+			end := strings.Index(rest, `"`)
+			if end == -1 {
+				return nil, fmt.Errorf("unclosed quote in %q", key)
+			}
+			segment, key = rest[:end], rest[end+1:]
+			if key != "" && !strings.HasPrefix(key, ".") {
+				return nil, fmt.Errorf("expected . after %q", segment)
+			}
+			key = strings.TrimPrefix(key, ".")
+		} else {
+			segment, key, _ = strings.Cut(key, ".")
+		}
+		if segment == "" {
+			return nil, errors.New("empty key segment")
+		}
+		keys = append(keys, segment)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("empty key")
+	}
+	return keys, nil
+}
+
+// joinConfigKey is the reverse of splitConfigKey.
+func joinConfigKey(keys []string) string {
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		if strings.Contains(key, ".") {
+			key = `"` + key + `"`
+		}
+		quoted[i] = key
+	}
+	return strings.Join(quoted, ".")
+}
+
+// parseConfigValue reads a value given on the command line as yaml, so
+// `true`, `0.7` and `[a, b]` are stored as a bool, number and list. Anything
+// that isn't a plain value or list is kept as a string.
+func parseConfigValue(value string) *yaml.Node {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err == nil && len(doc.Content) == 1 {
+		node := doc.Content[0]
+		if node.Kind == yaml.ScalarNode || node.Kind == yaml.SequenceNode {
+			node.Line, node.Column = 0, 0
+			return node
+		}
+	}
+	node := &yaml.Node{}
+	node.SetString(value)
+	return node
+}
+
+// lookupConfigValue finds a key in viper, ignoring case like viper does. The
+// settings are walked by hand since viper would split model names at their
+// dots.
+func lookupConfigValue(keys []string) (any, bool) {
+	if !viper.IsSet(keys[0]) {
+		return nil, false
+	}
+	value := viper.Get(keys[0])
+	for _, key := range keys[1:] {
+		mapping, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = mapping[strings.ToLower(key)]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// flattenConfig lists every leaf of settings by its full key.
+func flattenConfig(prefix []string, settings map[string]any, leaf func(keys []string, value any)) {
+	names := []string{}
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keys := append(prefix[:len(prefix):len(prefix)], name)
+		if nested, ok := settings[name].(map[string]any); ok && len(nested) > 0 {
+			flattenConfig(keys, nested, leaf)
+		} else {
+			leaf(keys, settings[name])
+		}
+	}
+}
+
+// isSecretKey reports whether a key holds something to keep off the screen.
+func isSecretKey(keys []string) bool {
+	if strings.EqualFold(keys[0], "secrets") {
+		return true
+	}
+	last := strings.ToLower(keys[len(keys)-1])
+	for _, word := range []string{"key", "token", "secret", "password"} {
+		if strings.Contains(last, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskSecret hides all but the last four characters of a secret.
+func maskSecret(secret string) string {
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", 8) + secret[len(secret)-4:]
+}
@@ -0,0 +1,262 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// contextWindows are the context sizes of well known models, matched by the
+// longest prefix of the model name.
+var contextWindows = map[string]int{
+	"gpt-3.5-turbo":      16385,
+	"gpt-3.5-turbo-0301": 4096,
+	"gpt-3.5-turbo-0613": 4096,
+	"gpt-3.5-turbo-16k":  16385,
+	"gpt-4":              8192,
+	"gpt-4-32k":          32768,
+	"gpt-4-turbo":        128000,
+	"gpt-4-1106":         128000,
+	"gpt-4-0125":         128000,
+	"gpt-4o":             128000,
+	"gpt-4.1":            1047576,
+	"o1":                 200000,
+	"o3":                 200000,
+	"o4-mini":            200000,
+	"llama2":             4096,
+	"llama3":             8192,
+	"llama3.1":           131072,
+	"mistral":            32768,
+	"mixtral":            32768,
+	"gemma":              8192,
+	"qwen2":              32768,
+	"deepseek":           65536,
+	"phi3":               4096,
+	"codellama":          16384,
+}
+
+const (
+	defaultContextWindow  = 4096
+	defaultContextReserve = 1024
+	// tokens each message costs on top of its content, and tokens that prime
+	// the reply
+	tokensPerMessage = 4
+	tokensPerReply   = 3
+)
+
+// modelSetting looks a key up under models.<model> in the config. Model names
+// often contain dots, so the map is walked by hand instead of through viper's
+// dotted keys.
+func modelSetting(model string, key string) (any, bool) {
+	entry, ok := viper.GetStringMap("models")[strings.ToLower(model)].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	value, ok := entry[key]
+	return value, ok
+}
+
+// contextWindow is the number of tokens the persona's model can take in: the
+// persona's `context-window`, then models.<model>.context-window, then the
+// built in table.
+func contextWindow(persona Persona) int {
+	if window, err := strconv.Atoi(personaSetting(persona, "context-window")); err == nil && window > 0 {
+		return window
+	}
+	if value, ok := modelSetting(persona.Model, "context-window"); ok {
+		if window, err := strconv.Atoi(fmt.Sprint(value)); err == nil && window > 0 {
+			return window
+		}
+	}
+
+	model := strings.ToLower(persona.Model)
+	window, longest := defaultContextWindow, 0
+	for prefix, size := range contextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > longest {
+			window, longest = size, len(prefix)
+		}
+	}
+	return window
+}
+
+// contextReserve is the number of tokens kept free for the reply: the
+// `context-reserve` setting, or else the persona's max_tokens.
+func contextReserve(persona Persona) int {
+	if reserve, err := strconv.Atoi(personaSetting(persona, "context-reserve")); err == nil && reserve >= 0 {
+		return reserve
+	}
+	if persona.Params.MaxTokens > 0 {
+		return persona.Params.MaxTokens
+	}
+	return defaultContextReserve
+}
+
+// estimateTokens approximates how many tokens text costs without a tokenizer:
+// short words are a token each, longer words one per four characters, and
+// punctuation and ideographs a token per character.
+func estimateTokens(text string) int {
+	tokens, word := 0, 0
+	flush := func() {
+		if word > 0 {
+			tokens += (word + 3) / 4
+			word = 0
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case (unicode.IsLetter(r) || unicode.IsDigit(r)) && !unicode.Is(unicode.Han, r):
+			word++
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
+
+// estimateMessagesTokens approximates the prompt tokens of a request.
+func estimateMessagesTokens(messages []openai.ChatCompletionMessage) int {
+	tokens := tokensPerReply
+	for _, message := range messages {
+		tokens += tokensPerMessage + estimateTokens(message.Content)
+	}
+	return tokens
+}
+
+// contextHistory returns the history to send to persona along with
+// userPrompt. When the conversation would overflow the persona's context
+// window, the oldest turns are summarised (with `context-strategy: summarize`)
+// or dropped, a whole exchange at a time.
+func (c *chatSession) contextHistory(ctx context.Context, persona Persona, userPrompt string) []openai.ChatCompletionMessage {
+	prompt := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: userPrompt}}
+	budget := contextWindow(persona) - contextReserve(persona) - estimateMessagesTokens(persona.Messages(prompt))
+
+	// undo and retry can reach back past what was summarised
+	if c.summarized > len(c.history) {
+		c.summarized, c.summary = 0, ""
+	}
+	history := c.summarizedHistory()
+	if estimateMessagesTokens(history) <= budget {
+		return history
+	}
+
+	if personaSetting(persona, "context-strategy") == "summarize" {
+		folded, err := c.summarize(ctx, budget)
+		if err == nil {
+			c.notice(fmt.Sprintf("summarised %d earlier messages to fit the context window of %s", folded, persona.Model))
+			history = c.summarizedHistory()
+			if estimateMessagesTokens(history) <= budget {
+				return history
+			}
+		} else {
+			c.notice("could not summarise the conversation, dropping the oldest messages instead: " + err.Error())
+		}
+	}
+
+	dropped := 0
+	for len(history) > 0 && estimateMessagesTokens(history) > budget {
+		// the oldest message goes with everything up to the next prompt, so
+		// what's kept never starts with a reply to a prompt that isn't there
+		next := 1
+		for next < len(history) && history[next].Role != openai.ChatMessageRoleUser {
+			next++
+		}
+		history = history[next:]
+		dropped += next
+	}
+	if dropped > 0 {
+		c.notice(fmt.Sprintf("left out the %d oldest messages to fit the context window of %s", dropped, persona.Model))
+	}
+	return history
+}
+
+// summarizedHistory is the history with everything that has been summarised
+// replaced by the summary.
+func (c *chatSession) summarizedHistory() []openai.ChatCompletionMessage {
+	history := []openai.ChatCompletionMessage{}
+	if c.summary != "" {
+		history = append(history, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "Summary of the earlier conversation:\n\n" + c.summary,
+		})
+	}
+	return append(history, c.history[c.summarized:]...)
+}
+
+// summarize folds the oldest unsummarised turns into the summary until the
+// rest fits in half the budget, always keeping the last exchange. It returns
+// how many messages were folded in.
+func (c *chatSession) summarize(ctx context.Context, budget int) (int, error) {
+	end := c.summarized
+	for end+2 < len(c.history) && estimateMessagesTokens(c.history[end:]) > budget/2 {
+		end += 2
+	}
+	if end == c.summarized {
+		return 0, fmt.Errorf("nothing left to summarise")
+	}
+
+	persona, provider, err := summaryPersona(c.resources)
+	if err != nil {
+		return 0, err
+	}
+	transcript := ""
+	if c.summary != "" {
+		transcript += div("summary so far") + c.summary
+	}
+	for _, message := range c.history[c.summarized:end] {
+		transcript += div(message.Role) + message.Content
+	}
+	summary, _, err := createChatCompletion(ctx, provider, persona, strings.TrimSpace(transcript), []openai.ChatCompletionMessage{})
+	if err != nil {
+		return 0, err
+	}
+
+	folded := end - c.summarized
+	c.summary, c.summarized = summary, end
+	return folded, nil
+}
+
+// summaryPersona is the persona named by `summary-persona`, or else the chat
+// persona's model with a built in summarising prompt.
+func summaryPersona(resources LoadedResources) (Persona, Provider, error) {
+	name := personaSetting(resources.ChatPersona, "summary-persona")
+	if name == "" {
+		persona := resources.ChatPersona
+		persona.Name = persona.Name + "-summary"
+		persona.SystemMessage = openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "Summarise the conversation you are given so it can replace the original as context for the rest of the conversation. Keep every fact, decision, name, command and piece of code that may be referred to later. Reply with the summary only.",
+		}
+		return persona, resources.ChatProvider, nil
+	}
+	persona, err := loadPersona(name)
+	if err != nil {
+		return Persona{}, nil, err
+	}
+	provider, err := providerFor(persona)
+	return persona, provider, err
+}
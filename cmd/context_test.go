@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briandowns/spinner"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestContextHistory(t *testing.T) {
+	// every message is 100 tokens, 104 with its overhead, and the prompt
+	// and system message leave the history 12 tokens short of the window
+	history := []openai.ChatCompletionMessage{}
+	for i := 0; i < 10; i++ {
+		role := openai.ChatMessageRoleUser
+		if i%2 == 1 {
+			role = openai.ChatMessageRoleAssistant
+		}
+		history = append(history, openai.ChatCompletionMessage{Role: role, Content: strings.Repeat("word ", 99) + string(rune('a'+i)) + "x"})
+	}
+
+	tests := []struct {
+		name     string
+		window   int
+		strategy string
+		reply    string
+		err      error
+		// want are the indexes of the history messages kept, after the
+		// summary if there is one
+		want     []int
+		summary  bool
+		requests int
+	}{
+		{name: "fits", window: 2000, want: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{name: "drops the oldest exchange", window: 1000, want: []int{2, 3, 4, 5, 6, 7, 8, 9}},
+		{name: "drops down to what fits", window: 500, want: []int{6, 7, 8, 9}},
+		{name: "drops everything", window: 50, want: []int{}},
+		{name: "summarizes", window: 1000, strategy: "summarize", reply: "they talked", want: []int{6, 7, 8, 9}, summary: true, requests: 1},
+		{name: "drops when summarizing fails", window: 1000, strategy: "summarize", err: errors.New("offline"), want: []int{2, 3, 4, 5, 6, 7, 8, 9}, requests: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testConfig(t, map[string]any{
+				"personas.trim.context-window":   test.window,
+				"personas.trim.context-reserve":  0,
+				"personas.trim.context-strategy": test.strategy,
+			})
+			provider := &fakeProvider{reply: test.reply, err: test.err}
+			persona := Persona{Name: "trim", Model: "test", SystemMessage: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem}}
+			c := &chatSession{
+				resources: LoadedResources{ChatPersona: persona, ChatProvider: provider},
+				history:   history,
+				spinner:   spinner.New(spinner.CharSets[19], time.Second),
+			}
+
+			got := c.contextHistory(context.Background(), persona, "hi")
+			if test.summary {
+				if len(got) == 0 || got[0].Role != openai.ChatMessageRoleSystem || !strings.HasSuffix(got[0].Content, test.reply) {
+					t.Fatalf("history doesn't start with the summary: %v", got)
+				}
+				got = got[1:]
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("kept %d messages, want %d", len(got), len(test.want))
+			}
+			if len(got) > 0 && got[0].Role != openai.ChatMessageRoleUser {
+				t.Errorf("kept history starts with a %s message", got[0].Role)
+			}
+			for i, index := range test.want {
+				if got[i].Role != history[index].Role || got[i].Content != history[index].Content {
+					t.Errorf("message %d ends %q, want message %d", i, got[i].Content[len(got[i].Content)-2:], index)
+				}
+			}
+			if len(provider.requests) != test.requests {
+				t.Errorf("sent %d summary requests, want %d", len(provider.requests), test.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
+	Use:   "doctor",
+	Short: "Check the config and environment for problems",
+	Long: `Check the config file, every persona it refers to, the log directory, api keys
+and pager, printing how to fix anything that's wrong. With --ping, also ask each
+persona's provider which models it serves. Exits non-zero if anything failed.
+For example:
+
+  yoo doctor
+  yoo doctor --ping`,
+	Run: func(cmd *cobra.Command, args []string) {
+		stubTemplateHelpers = true
+		d := &doctor{}
+		if d.checkConfigFile() {
+			for _, persona := range doctorPersonas() {
+				d.checkPersona(persona.name, persona.roles)
+			}
+		}
+		d.checkProjects()
+		d.checkLogPath()
+		d.checkPager()
+
+		if d.failures == 1 {
+			fmt.Println("\n1 problem found")
+			os.Exit(1)
+		} else if d.failures > 0 {
+			fmt.Printf("\n%d problems found\n", d.failures)
+			os.Exit(1)
+		}
+		fmt.Println("\nall good")
+	},
+}
+
+var doctorPing bool
+
+// doctor prints the result of each check as it's made.
+type doctor struct {
+	failures        int
+	plaintextWarned bool
+}
+
+func (d *doctor) pass(message string) {
+	fmt.Println("✓ " + message)
+}
+
+func (d *doctor) warn(message string, fix string) {
+	fmt.Println("! " + message)
+	if fix != "" {
+		fmt.Println("    fix: " + fix)
+	}
+}
+
+func (d *doctor) fail(message string, fix string) {
+	d.failures++
+	fmt.Println("✗ " + message)
+	if fix != "" {
+		fmt.Println("    fix: " + fix)
+	}
+}
+
+func (d *doctor) checkConfigFile() bool {
+	path := configFilePath()
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		d.fail("no config file at "+path, "create it with a persona and your api key, see the README")
+		return false
+	}
+	if err != nil {
+		d.fail("could not read "+path+": "+err.Error(), "check the file's permissions")
+		return false
+	}
+	if err := yaml.Unmarshal(content, &map[string]any{}); err != nil {
+		d.fail(path+" is not valid yaml: "+err.Error(), "yoo config edit")
+		return false
+	}
+	d.pass("config file " + path)
+
+	if viper.GetString("persona") == "" {
+		d.fail("no default persona", "yoo config --persona <name>")
+	}
+	if viper.GetString("title-persona") == "" {
+		d.fail("no title-persona to name logs with", "yoo config set title-persona <name>")
+	}
+	if viper.GetString("quick-persona") == "" {
+		d.warn("no quick-persona, so `yoo quick` won't work", "yoo config set quick-persona <name>")
+	}
+	return true
+}
+
+type doctorPersona struct {
+	name  string
+	roles []string
+}
+
+// doctorPersonas lists every persona the config refers to, with what it's
+// used for.
+func doctorPersonas() []doctorPersona {
+	roles := map[string][]string{}
+	for _, key := range []string{"persona", "title-persona", "quick-persona", "summary-persona"} {
+		if name := viper.GetString(key); name != "" {
+			roles[name] = append(roles[name], key)
+		}
+	}
+	for name := range viper.GetStringMap("personas") {
+		if _, ok := roles[name]; !ok {
+			roles[name] = []string{}
+		}
+	}
+	personas := []doctorPersona{}
+	for name, used := range roles {
+		personas = append(personas, doctorPersona{name, used})
+	}
+	sort.Slice(personas, func(i, j int) bool { return personas[i].name < personas[j].name })
+	return personas
+}
+
+func (d *doctor) checkPersona(name string, roles []string) {
+	label := "persona " + name
+	if len(roles) > 0 {
+		label += " (" + strings.Join(roles, ", ") + ")"
+	}
+
+	persona, err := loadPersona(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		d.fail(label+" has no system prompt file in "+viper.GetString("configpath"), "yoo config persona "+name)
+		return
+	}
+	if err != nil {
+		d.fail(label+": "+err.Error(), "fix the persona file or personas."+name+" in the config")
+		return
+	}
+	if persona.Model == "" && persona.Provider != "llamacpp" {
+		d.fail(label+" has no model", "yoo config persona "+name+" --set-model <model>")
+		return
+	}
+	provider, err := providerFor(persona)
+	if err != nil {
+		names := []string{}
+		for provider := range providers {
+			names = append(names, provider)
+		}
+		sort.Strings(names)
+		d.fail(label+": "+err.Error(), "yoo config set personas."+name+".provider <"+strings.Join(names, "|")+">")
+		return
+	}
+	if persona.Provider == "" || persona.Provider == defaultProvider {
+		if !d.checkKey(label, persona) {
+			return
+		}
+	}
+	for _, dir := range persona.Knowledge {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			d.warn(label+" answers from "+shortPath(dir)+", which isn't a directory", "fix knowledge: in the persona file")
+		}
+	}
+	if len(persona.Knowledge) > 0 || personaSetting(persona, "recall") != "" {
+		if _, err := embeddingFor(persona); err != nil {
+			d.fail(label+": "+err.Error(), "yoo config set personas."+name+".embedding-persona <name>")
+			return
+		}
+	}
+
+	if doctorPing {
+		d.ping(label, persona, provider)
+		return
+	}
+	d.pass(label + " uses " + persona.Model)
+}
+
+// checkKey checks the api key used by an openai persona.
+func (d *doctor) checkKey(label string, persona Persona) bool {
+	key, source, err := resolveSecret(openAIKeySecret, persona)
+	if err != nil {
+		d.fail(label+": "+err.Error(), "yoo auth status")
+		return false
+	}
+	if key == "" {
+		d.fail(label+" needs an api key, but none is set", "yoo auth login")
+		return false
+	}
+	if strings.TrimSpace(key) != key || strings.ContainsAny(key, " \t\n") {
+		d.fail(label+": the api key from "+source+" contains whitespace", "yoo auth login")
+		return false
+	}
+	openAI := personaSetting(persona, "base-url") == "" && personaSetting(persona, "api-type") != "azure"
+	if openAI && !strings.HasPrefix(key, "sk-") {
+		d.warn(label+": the api key from "+source+" doesn't look like an openai key (they start with sk-)", "yoo auth login")
+	}
+	if source == "config" && !d.plaintextWarned {
+		d.plaintextWarned = true
+		d.warn("the api key is stored in plaintext in the config", "yoo auth login, then yoo config unset secrets."+openAIKeySecret)
+	}
+	return true
+}
+
+// ping asks the persona's provider for its models, to check that it can be
+// reached and serves the persona's model.
+func (d *doctor) ping(label string, persona Persona, provider Provider) {
+	lister, ok := provider.(ModelLister)
+	if !ok {
+		d.pass(label + " uses " + persona.Model + " (its provider can't be pinged)")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	models, err := lister.Models(ctx)
+	if err != nil {
+		d.fail(label+" could not reach its provider: "+err.Error(), "check that the server is running, and the persona's endpoint or base-url and api key")
+		return
+	}
+	for _, model := range models {
+		if model == persona.Model || strings.TrimSuffix(model, ":latest") == persona.Model {
+			d.pass(label + " uses " + persona.Model + ", which its provider serves")
+			return
+		}
+	}
+	if persona.Provider == "ollama" {
+		d.fail(label+" uses "+persona.Model+", which hasn't been pulled", "ollama pull "+persona.Model)
+		return
+	}
+	d.warn(label+" uses "+persona.Model+", which its provider doesn't list", "yoo models "+persona.Name)
+}
+
+// checkProjects reports the keys each project's config.yml sets that were
+// ignored, so a project that doesn't work as its readme says can be told
+// apart from a broken one.
+func (d *doctor) checkProjects() {
+	for _, p := range projects {
+		label := "project " + shortPath(p.Root())
+		if p.Trusted {
+			label += " (trusted)"
+		}
+		if len(p.Dropped) == 0 {
+			d.pass(label)
+			continue
+		}
+		fix := "set them in " + configFilePath() + " instead"
+		if !p.Trusted {
+			fix = "yoo project trust " + p.Root() + ", once you've read its .yoo/"
+		}
+		d.warn(label+" sets "+strings.Join(p.Dropped, ", ")+", which are ignored", fix)
+	}
+}
+
+func (d *doctor) checkLogPath() {
+	logPath := viper.GetString("logpath")
+	info, err := os.Stat(logPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		d.fail("log directory "+logPath+" doesn't exist", "mkdir -p "+logPath)
+		return
+	}
+	if err != nil || !info.IsDir() {
+		d.fail("log directory "+logPath+" is not a directory", "move it out of the way and mkdir -p "+logPath)
+		return
+	}
+	probe, err := os.CreateTemp(logPath, ".yoo-doctor-*")
+	if err != nil {
+		d.fail("log directory "+logPath+" is not writable: "+err.Error(), "chmod u+w "+logPath)
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	d.pass("log directory " + logPath)
+}
+
+func (d *doctor) checkPager() {
+	fields := pagerCommand()
+	if len(fields) == 0 {
+		d.fail("pager is blank, so `yoo peep` won't work", "yoo config unset pager, or yoo config set pager <command>")
+		return
+	}
+	pager := strings.Join(fields, " ")
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		d.fail("pager "+pager+" is not installed, so `yoo peep` won't work", "install it, or yoo config set pager <command>")
+		return
+	}
+	d.pass("pager " + pager)
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// doctorCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	doctorCmd.Flags().BoolVar(&doctorPing, "ping", false, "check that each persona's provider can be reached")
+}
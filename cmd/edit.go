@@ -0,0 +1,128 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// editCmd represents the edit command
+var editCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
+	Use:   "edit",
+	Short: "Edit the config file",
+	Long: `Open the config file in $VISUAL or $EDITOR (or vim). It is checked once the
+editor closes, and if it's broken you can edit it again or put the old one back.
+For example:
+
+  yoo config edit`,
+	Run: func(cmd *cobra.Command, args []string) {
+		stubTemplateHelpers = true
+		path := configFilePath()
+		original, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			checkError(err, "could not read config", true)
+		}
+
+		for {
+			checkError(openInEditor(path), "could not edit "+path, true)
+			problems, err := validateConfigFile(path)
+			if err == nil {
+				for _, problem := range problems {
+					fmt.Println("warning: " + problem)
+				}
+				return
+			}
+			fmt.Println(path + " is invalid: " + err.Error())
+			fmt.Println("edit it again? 'n' puts back the config from before")
+			if !confirmWithUser() {
+				if original == nil {
+					err = os.Remove(path)
+				} else {
+					err = os.WriteFile(path, original, 0644)
+				}
+				checkError(err, "could not restore "+path, true)
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// validateConfigFile loads the config file at path, returning an error if it
+// can't be used and a list of problems that are worth knowing about, like
+// personas that don't have a system prompt yet.
+func validateConfigFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]any{}
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return nil, err
+	}
+	viper.SetConfigFile(path)
+	if err := readConfig(); err != nil {
+		return nil, err
+	}
+
+	problems := []string{}
+	names := []string{}
+	for name := range viper.GetStringMap("personas") {
+		names = append(names, name)
+	}
+	for _, key := range []string{"persona", "title-persona"} {
+		if name := viper.GetString(key); name != "" {
+			names = append(names, name)
+		} else {
+			problems = append(problems, key+" is not set")
+		}
+	}
+	checked := map[string]bool{}
+	for _, name := range names {
+		if checked[name] {
+			continue
+		}
+		checked[name] = true
+		if _, err := loadPersona(name); errors.Is(err, fs.ErrNotExist) {
+			problems = append(problems, "persona "+name+" has no system prompt file in "+viper.GetString("configpath"))
+		} else if err != nil {
+			return nil, fmt.Errorf("persona %s: %w", name, err)
+		}
+	}
+	return problems, nil
+}
+
+func init() {
+	configCmd.AddCommand(editCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// editCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// editCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
@@ -0,0 +1,194 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// defaultEmbeddingModels are used for personas without an `embedding-model`.
+var defaultEmbeddingModels = map[string]string{
+	"openai": "text-embedding-3-small",
+	"ollama": "nomic-embed-text",
+}
+
+// embedBatchSize is how many texts are sent in one embeddings request, and
+// embedBatchTokens about how many tokens, to stay under the input limits of
+// hosted embeddings apis.
+const (
+	embedBatchSize   = 64
+	embedBatchTokens = 32000
+)
+
+// embedTextLimit caps the characters of a text that are embedded, to stay
+// inside what embedding models take in.
+const embedTextLimit = 6000
+
+// embedding is where a persona's embeddings come from: the provider of the
+// persona named by `embedding-persona`, or else the persona's own.
+type embedding struct {
+	persona  Persona
+	embedder Embedder
+	model    string
+}
+
+func embeddingFor(persona Persona) (embedding, error) {
+	if name := personaSetting(persona, "embedding-persona"); name != "" && name != persona.Name {
+		other, err := loadPersona(name)
+		if err != nil {
+			return embedding{}, err
+		}
+		persona = other
+	}
+	provider, err := providerFor(persona)
+	if err != nil {
+		return embedding{}, err
+	}
+	name := persona.Provider
+	if name == "" {
+		name = defaultProvider
+	}
+	embedder, ok := provider.(Embedder)
+	if !ok {
+		return embedding{}, fmt.Errorf("the %s provider of persona %s can't embed text, set embedding-persona to one that can", name, persona.Name)
+	}
+	model := personaSetting(persona, "embedding-model")
+	if model == "" {
+		model = defaultEmbeddingModels[name]
+	}
+	return embedding{persona, embedder, model}, nil
+}
+
+// ID names the model the vectors come from. Vectors from different models
+// can't be compared.
+func (e embedding) ID() string {
+	provider := e.persona.Provider
+	if provider == "" {
+		provider = defaultProvider
+	}
+	return provider + "/" + e.model
+}
+
+// indexName names the file an index of vectors from model is kept in. Each
+// model gets its own, so personas embedding with different models don't
+// keep starting each other's over.
+func indexName(prefix string, model string) string {
+	sum := sha256.Sum256([]byte(model))
+	return prefix + "-" + hex.EncodeToString(sum[:6])
+}
+
+// embed turns texts into unit vectors, in batches, recording the usage in
+// the ledger. All of the texts are held to the budget before the first batch
+// is sent.
+func (e embedding) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	estimate := Usage{}
+	for _, text := range texts {
+		estimate.PromptTokens += estimateTokens(text)
+	}
+	err := checkSpend(e.persona.Name, e.model, estimate, func(warning string) {
+		fmt.Fprintln(os.Stderr, "warning: "+warning)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := [][]float32{}
+	for start := 0; start < len(texts); {
+		end, tokens := start, 0
+		for end < len(texts) && end-start < embedBatchSize {
+			tokens += estimateTokens(texts[end])
+			if end > start && tokens > embedBatchTokens {
+				break
+			}
+			end++
+		}
+		batch, usage, err := e.embedder.Embed(ctx, e.model, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		e.recordUsage(texts[start:end], usage)
+		for _, vector := range batch {
+			vectors = append(vectors, normalize(vector))
+		}
+		start = end
+	}
+	return vectors, nil
+}
+
+func (e embedding) recordUsage(texts []string, usage Usage) {
+	if usage.PromptTokens == 0 {
+		for _, text := range texts {
+			usage.PromptTokens += estimateTokens(text)
+		}
+		usage.Estimated = true
+	}
+	cost, priced := usageCost(e.model, usage)
+	record := usageRecord{
+		Time:     time.Now().Local(),
+		Persona:  e.persona.Name,
+		Model:    e.model,
+		Provider: e.persona.Provider,
+		Usage:    usage,
+		Cost:     cost,
+		Unpriced: !priced,
+	}
+	checkError(appendUsage(record), "could not record usage", false)
+}
+
+// clipForEmbedding cuts text down to embedTextLimit characters.
+func clipForEmbedding(text string) string {
+	runes := []rune(text)
+	if len(runes) > embedTextLimit {
+		return string(runes[:embedTextLimit])
+	}
+	return text
+}
+
+// normalize scales a vector to unit length, so similarity is a dot product.
+func normalize(vector []float32) []float32 {
+	sum := 0.0
+	for _, x := range vector {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return vector
+	}
+	norm := float32(math.Sqrt(sum))
+	unit := make([]float32, len(vector))
+	for i, x := range vector {
+		unit[i] = x / norm
+	}
+	return unit
+}
+
+// similarity is the cosine similarity of two unit vectors.
+func similarity(a []float32, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float32
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return dot
+}
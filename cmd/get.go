@@ -0,0 +1,88 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// getCmd represents the get command
+var getCmd = &cobra.Command{
+	Args:  cobra.ExactArgs(1),
+	Use:   "get <key>",
+	Short: "Print a config value",
+	Long: `Print a config value by its dotted key. Keys with dots of their own are quoted.
+For example:
+
+  yoo config get personas.archie.model
+  yoo config get 'models."gpt-3.5-turbo".price'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		keys, err := splitConfigKey(args[0])
+		checkError(err, "invalid key "+args[0], true)
+		value, ok := lookupConfigValue(keys)
+		if !ok {
+			checkError(fmt.Errorf("%s is not set", args[0]), "could not get "+args[0], true)
+		}
+		if _, nested := value.(map[string]any); nested {
+			encoded, err := encodeYAML(value)
+			checkError(err, "could not print "+args[0], true)
+			fmt.Print(string(encoded))
+			return
+		}
+		fmt.Println(formatConfigValue(value))
+	},
+}
+
+// formatConfigValue prints a config value on one line, with lists in yaml's
+// flow style so they can be given back to `yoo config set`.
+func formatConfigValue(value any) string {
+	switch value.(type) {
+	case nil:
+		return ""
+	case []any, []string, map[string]any:
+		node := &yaml.Node{}
+		if err := node.Encode(value); err != nil {
+			return fmt.Sprint(value)
+		}
+		node.Style = yaml.FlowStyle
+		encoded, err := yaml.Marshal(node)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+		return strings.TrimSpace(string(encoded))
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func init() {
+	configCmd.AddCommand(getCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// getCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// getCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
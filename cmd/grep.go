@@ -0,0 +1,133 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	termutil "github.com/andrew-d/go-termutil"
+	"github.com/spf13/cobra"
+)
+
+// grepCmd represents the grep command
+var grepCmd = &cobra.Command{
+	Args:  cobra.ExactArgs(1),
+	Use:   "grep <regex>",
+	Short: "Search logs",
+	Long: `Search every log for a regular expression, printing the matching lines with
+the lines around them. Each log is shown with its number for yoo peep <n>.
+For example:
+
+  yoo peep grep 'pacman -S[a-z]*'
+  yoo peep grep -i --persona archie mirrorlist`,
+	Run: func(cmd *cobra.Command, args []string) {
+		expr := args[0]
+		if grepIgnoreCase {
+			expr = "(?i)" + expr
+		}
+		pattern, err := regexp.Compile(expr)
+		checkError(err, "invalid regex", true)
+
+		entries, err := listLogs()
+		checkError(err, "could not list logs", true)
+		filtered, err := grepFilter.apply(entries)
+		checkError(err, "could not filter logs", true)
+
+		highlight := highlighter(termutil.Isatty(os.Stdout.Fd()))
+
+		found := false
+		for _, entry := range filtered {
+			content, err := os.ReadFile(entry.Path)
+			if err != nil {
+				continue
+			}
+			lines := strings.Split(string(content), "\n")
+			shown := -1
+			for i, line := range lines {
+				if messageMarker.MatchString(line) || !pattern.MatchString(line) {
+					continue
+				}
+				if !found || shown == -1 {
+					if found {
+						fmt.Println()
+					}
+					fmt.Println(highlight(fmt.Sprintf("%d  %s  %s", logNumber(entries, entry), entry.Date().Format("2006-01-02"), entry.Title()), "1;34"))
+				}
+				found = true
+
+				start, end := i-grepContext, i+grepContext
+				if start < 0 {
+					start = 0
+				}
+				if end >= len(lines) {
+					end = len(lines) - 1
+				}
+				if shown != -1 && start > shown+1 {
+					fmt.Println(highlight("--", "2"))
+				}
+				if start <= shown {
+					start = shown + 1
+				}
+				for j := start; j <= end; j++ {
+					if messageMarker.MatchString(lines[j]) {
+						continue
+					}
+					text := lines[j]
+					if pattern.MatchString(text) {
+						text = pattern.ReplaceAllStringFunc(text, func(match string) string {
+							return highlight(match, "1;31")
+						})
+					}
+					fmt.Printf("%s%s\n", highlight(fmt.Sprintf("%5d: ", j+1), "2"), text)
+				}
+				if end > shown {
+					shown = end
+				}
+			}
+		}
+		if !found {
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	grepFilter     logFilter
+	grepIgnoreCase bool
+	grepContext    int
+)
+
+func init() {
+	peepCmd.AddCommand(grepCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// grepCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	grepCmd.Flags().BoolVarP(&grepIgnoreCase, "ignore-case", "i", false, "ignore case")
+	grepCmd.Flags().IntVarP(&grepContext, "context", "C", 1, "lines of context to show around matches")
+	grepCmd.Flags().StringVar(&grepFilter.persona, "persona", "", "only search logs with this persona")
+	grepCmd.Flags().StringVar(&grepFilter.since, "since", "", "only search logs from this date on (YYYY-MM-DD)")
+	grepCmd.Flags().StringVar(&grepFilter.until, "until", "", "only search logs up to this date (YYYY-MM-DD)")
+}
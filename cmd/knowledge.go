@@ -0,0 +1,84 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// knowledgeCmd represents the knowledge command
+var knowledgeCmd = &cobra.Command{
+	Args:  cobra.MaximumNArgs(1),
+	Use:   "knowledge [persona]",
+	Short: "Embed the documents a persona answers from",
+	Long: `Embed the documents in a persona's knowledge directories that are new or have
+changed, so the first question doesn't have to wait for them. This happens on
+its own whenever the persona is asked something. For example:
+
+  yoo index knowledge
+  yoo index knowledge runbooks`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := viper.GetString("persona")
+		if len(args) > 0 {
+			name = args[0]
+		}
+		persona, err := loadPersona(name)
+		checkError(err, "could not load persona "+name, true)
+		if len(persona.Knowledge) == 0 {
+			fmt.Println(name + " has no knowledge directories, list them under knowledge: in its persona file")
+			os.Exit(1)
+		}
+
+		e, err := embeddingFor(persona)
+		checkError(err, "could not set up embeddings for "+name, true)
+		index := loadKnowledgeIndex(viper.GetString("logpath"), e.ID())
+		count, err := index.refresh(context.Background(), e, persona.Knowledge, func(message string) {
+			if !viper.GetBool("quiet") {
+				fmt.Fprintln(os.Stderr, "("+message+")")
+			}
+		})
+		checkError(err, "could not index knowledge of "+name, true)
+
+		files, chunks := 0, 0
+		for path, indexed := range index.Files {
+			if inKnowledge(path, persona.Knowledge) {
+				files++
+				chunks += len(indexed.Chunks)
+			}
+		}
+		fmt.Printf("embedded %d documents, %s knows %d documents in %d chunks\n", count, name, files, chunks)
+	},
+}
+
+func init() {
+	indexCmd.AddCommand(knowledgeCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// knowledgeCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// knowledgeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
@@ -0,0 +1,377 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// knowledgeIndexVersion is bumped whenever how documents are chunked changes.
+const knowledgeIndexVersion = 1
+
+const (
+	// defaultKnowledgeChunks is how many chunks are added to a prompt when
+	// the persona doesn't set `knowledge-chunks`.
+	defaultKnowledgeChunks = 4
+	// knowledgeChunkSize is the size in bytes chunks are cut at, at the next
+	// blank line, or at twice the size if there isn't one.
+	knowledgeChunkSize = 1500
+	// knowledgeFileLimit is the size of the largest document that's indexed.
+	knowledgeFileLimit = 1 << 20
+)
+
+// knowledgeExtensions are the documents that are indexed.
+var knowledgeExtensions = map[string]bool{
+	".md":       true,
+	".markdown": true,
+	".txt":      true,
+}
+
+// knowledgeIndex holds a vector for every chunk of the documents in the
+// personas' knowledge directories, kept in the log directory as
+// .knowledge-index-<model hash>, one for each embedding model. Documents are
+// embedded again when their mtime changes.
+type knowledgeIndex struct {
+	Version int
+	// Model is the embedding the vectors come from, as embedding.ID.
+	Model string
+	// Files are the indexed documents by absolute path.
+	Files map[string]knowledgeFile
+
+	dir string
+}
+
+type knowledgeFile struct {
+	ModTime time.Time
+	Size    int64
+	Chunks  []knowledgeChunk
+}
+
+// knowledgeChunk is a run of lines of a document, numbered from 1.
+type knowledgeChunk struct {
+	StartLine int
+	EndLine   int
+	Vector    []float32
+}
+
+// knowledgeMatch is a chunk similar to a prompt.
+type knowledgeMatch struct {
+	Path      string
+	StartLine int
+	EndLine   int
+	Score     float32
+	Text      string
+}
+
+// Citation is how the chunk is referred to, path:start-end.
+func (m knowledgeMatch) Citation() string {
+	if m.StartLine == m.EndLine {
+		return fmt.Sprintf("%s:%d", shortPath(m.Path), m.StartLine)
+	}
+	return fmt.Sprintf("%s:%d-%d", shortPath(m.Path), m.StartLine, m.EndLine)
+}
+
+func knowledgeIndexPath(dir string, model string) string {
+	return filepath.Join(dir, indexName(".knowledge-index", model))
+}
+
+// loadKnowledgeIndex reads the vectors model made that are kept in dir,
+// starting over when they're missing or unreadable.
+func loadKnowledgeIndex(dir string, model string) *knowledgeIndex {
+	empty := &knowledgeIndex{Version: knowledgeIndexVersion, Model: model, Files: map[string]knowledgeFile{}, dir: dir}
+	file, err := os.Open(knowledgeIndexPath(dir, model))
+	if err != nil {
+		return empty
+	}
+	defer file.Close()
+	var index knowledgeIndex
+	if err := gob.NewDecoder(file).Decode(&index); err != nil || index.Version != knowledgeIndexVersion || index.Model != model {
+		return empty
+	}
+	index.dir = dir
+	return &index
+}
+
+// save writes the index atomically.
+func (ix *knowledgeIndex) save() error {
+	tmp, err := os.CreateTemp(ix.dir, ".knowledge-index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(ix); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), knowledgeIndexPath(ix.dir, ix.Model))
+}
+
+// refresh embeds the documents in dirs that are new or changed and drops the
+// ones that are gone, returning how many were embedded. Progress and missing
+// directories are passed to notice. What was embedded is saved even if a
+// later request fails.
+func (ix *knowledgeIndex) refresh(ctx context.Context, e embedding, dirs []string, notice func(string)) (int, error) {
+	seen := map[string]bool{}
+	pending := []string{}
+	for _, dir := range dirs {
+		// a symlinked directory is walked where it points, keeping the paths
+		// under dir
+		root, err := filepath.EvalSymlinks(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			notice("knowledge directory " + shortPath(dir) + " doesn't exist")
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if rel, relErr := filepath.Rel(root, path); relErr == nil {
+				path = filepath.Join(dir, rel)
+			}
+			if err != nil {
+				if path == dir {
+					return err
+				}
+				notice("skipping " + shortPath(path) + ", it can't be read: " + err.Error())
+				if entry != nil && entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasPrefix(entry.Name(), ".") && path != dir {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() || !knowledgeExtensions[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil || info.Size() > knowledgeFileLimit {
+				return nil
+			}
+			seen[path] = true
+			indexed, ok := ix.Files[path]
+			if !ok || !indexed.ModTime.Equal(info.ModTime()) || indexed.Size != info.Size() {
+				pending = append(pending, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	changed := false
+	for path := range ix.Files {
+		if !seen[path] && inKnowledge(path, dirs) {
+			delete(ix.Files, path)
+			changed = true
+		}
+	}
+	if len(pending) > 0 {
+		notice(fmt.Sprintf("embedding %d documents of knowledge", len(pending)))
+	}
+
+	embedded := 0
+	for _, path := range pending {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		indexed := knowledgeFile{ModTime: info.ModTime(), Size: info.Size()}
+		texts := []string{}
+		for _, chunk := range chunkDocument(string(content)) {
+			indexed.Chunks = append(indexed.Chunks, knowledgeChunk{StartLine: chunk.startLine, EndLine: chunk.endLine})
+			texts = append(texts, clipForEmbedding(shortPath(path)+"\n\n"+chunk.text))
+		}
+		vectors, err := e.embed(ctx, texts)
+		if err != nil {
+			if changed {
+				ix.save()
+			}
+			return embedded, err
+		}
+		for i := range indexed.Chunks {
+			indexed.Chunks[i].Vector = vectors[i]
+		}
+		ix.Files[path] = indexed
+		changed = true
+		embedded++
+	}
+	if !changed {
+		return 0, nil
+	}
+	return embedded, ix.save()
+}
+
+// nearest returns the k chunks of documents in dirs most similar to vector,
+// with their text read back from the documents.
+func (ix *knowledgeIndex) nearest(vector []float32, dirs []string, k int) []knowledgeMatch {
+	matches := []knowledgeMatch{}
+	for path, indexed := range ix.Files {
+		if !inKnowledge(path, dirs) {
+			continue
+		}
+		for _, chunk := range indexed.Chunks {
+			matches = append(matches, knowledgeMatch{
+				Path:      path,
+				StartLine: chunk.StartLine,
+				EndLine:   chunk.EndLine,
+				Score:     similarity(vector, chunk.Vector),
+			})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	found := []knowledgeMatch{}
+	for _, match := range matches {
+		if len(found) == k {
+			break
+		}
+		content, err := os.ReadFile(match.Path)
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(string(content), "\n")
+		if match.EndLine > len(lines) {
+			continue
+		}
+		match.Text = strings.Join(lines[match.StartLine-1:match.EndLine], "\n")
+		found = append(found, match)
+	}
+	return found
+}
+
+// inKnowledge reports whether path is inside one of dirs.
+func inKnowledge(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(dir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+type documentChunk struct {
+	startLine int
+	endLine   int
+	text      string
+}
+
+// chunkDocument splits a document at its headings, and sections that are
+// too long at blank lines, never inside a code block unless it's huge.
+func chunkDocument(content string) []documentChunk {
+	lines := strings.Split(content, "\n")
+	blank := func(i int) bool { return strings.TrimSpace(lines[i]) == "" }
+	chunks := []documentChunk{}
+	start, size, fenced := 0, 0, false
+	flush := func(end int) {
+		first, last := start, end
+		for first < last && blank(first) {
+			first++
+		}
+		for last > first && blank(last-1) {
+			last--
+		}
+		if first < last {
+			chunks = append(chunks, documentChunk{first + 1, last, strings.Join(lines[first:last], "\n")})
+		}
+		start, size = end, 0
+	}
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+		}
+		heading := !fenced && strings.HasPrefix(line, "#")
+		if i > start && (heading || (size >= knowledgeChunkSize && !fenced && blank(i)) || size >= 2*knowledgeChunkSize) {
+			flush(i)
+		}
+		size += len(line) + 1
+	}
+	flush(len(lines))
+	return chunks
+}
+
+// searchKnowledge finds the k chunks of the persona's knowledge closest to
+// prompt, embedding any documents that changed first.
+func searchKnowledge(ctx context.Context, persona Persona, prompt string, k int, notice func(string)) ([]knowledgeMatch, error) {
+	e, err := embeddingFor(persona)
+	if err != nil {
+		return nil, err
+	}
+	index := loadKnowledgeIndex(viper.GetString("logpath"), e.ID())
+	if _, err := index.refresh(ctx, e, persona.Knowledge, notice); err != nil {
+		return nil, err
+	}
+	vectors, err := e.embed(ctx, []string{clipForEmbedding(prompt)})
+	if err != nil {
+		return nil, err
+	}
+	return index.nearest(vectors[0], persona.Knowledge, k), nil
+}
+
+// withKnowledge adds the chunks of the persona's knowledge closest to prompt
+// to its system message, `knowledge-chunks` of them, with their file and
+// lines to cite. Progress and problems are passed to notice, and on failure
+// the persona is used as it is.
+func withKnowledge(ctx context.Context, persona Persona, prompt string, notice func(string)) Persona {
+	if len(persona.Knowledge) == 0 {
+		return persona
+	}
+	k := defaultKnowledgeChunks
+	if n, err := strconv.Atoi(personaSetting(persona, "knowledge-chunks")); err == nil && n > 0 {
+		k = n
+	}
+	matches, err := searchKnowledge(ctx, persona, prompt, k, notice)
+	if err != nil {
+		notice("could not search knowledge: " + err.Error())
+		return persona
+	}
+	if len(matches) == 0 {
+		return persona
+	}
+
+	var context strings.Builder
+	context.WriteString("\n\nAnswer from these excerpts of your documents where they apply, and cite the ones you use by the path:line in their heading.")
+	for _, match := range matches {
+		fmt.Fprintf(&context, "\n\n### %s\n\n%s", match.Citation(), match.Text)
+	}
+	persona.SystemMessage.Content = strings.TrimRight(persona.SystemMessage.Content, "\n") + context.String()
+	return persona
+}
@@ -17,23 +17,20 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/spf13/cobra"
 )
 
 // lastCmd represents the last command
 var lastCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
 	Use:   "last",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Short: "Resume the most recent conversation",
+	Long: `Resume the most recent conversation in the log directory, with the persona
+it was held with. For example:
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+  yoo chat last`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("last called")
+		resumeChat(getLatest())
 	},
 }
 
@@ -0,0 +1,139 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// usageRecord is one request in the usage ledger, a json lines file next to
+// the logs.
+type usageRecord struct {
+	Time     time.Time `json:"time"`
+	Persona  string    `json:"persona"`
+	Model    string    `json:"model"`
+	Provider string    `json:"provider,omitempty"`
+	Usage
+	Cost float64 `json:"cost"`
+	// Unpriced is set when the model had no price in the config.
+	Unpriced bool `json:"unpriced,omitempty"`
+}
+
+func usageLedgerPath() string {
+	if path := viper.GetString("usage-path"); path != "" {
+		return path
+	}
+	return filepath.Join(viper.GetString("logpath"), "usage.jsonl")
+}
+
+// modelPrice is the price per million prompt and completion tokens from
+// models.<model>.price in the config.
+func modelPrice(model string) (float64, float64, bool) {
+	value, ok := modelSetting(model, "price")
+	if !ok {
+		return 0, 0, false
+	}
+	price := cast.ToStringMap(value)
+	return cast.ToFloat64(price["prompt"]), cast.ToFloat64(price["completion"]), true
+}
+
+// usageCost prices usage for a model, reporting false if the model has no
+// price.
+func usageCost(model string, usage Usage) (float64, bool) {
+	prompt, completion, ok := modelPrice(model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.PromptTokens)*prompt + float64(usage.CompletionTokens)*completion) / 1e6, true
+}
+
+// recordUsage adds a request to the ledger, estimating its usage if the
+// provider didn't report any, and returns the usage recorded. A ledger that
+// can't be written only prints a warning.
+func recordUsage(persona Persona, history []openai.ChatCompletionMessage, reply string, usage Usage) Usage {
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		usage = Usage{
+			PromptTokens:     estimateMessagesTokens(persona.Messages(history)),
+			CompletionTokens: estimateTokens(reply),
+			Estimated:        true,
+		}
+	}
+	cost, priced := usageCost(persona.Model, usage)
+	record := usageRecord{
+		Time:     time.Now().Local(),
+		Persona:  persona.Name,
+		Model:    persona.Model,
+		Provider: persona.Provider,
+		Usage:    usage,
+		Cost:     cost,
+		Unpriced: !priced,
+	}
+	checkError(appendUsage(record), "could not record usage", false)
+	return usage
+}
+
+func appendUsage(record usageRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(usageLedgerPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readUsage reads the ledger entries since a time. A missing ledger is empty.
+func readUsage(since time.Time) ([]usageRecord, error) {
+	file, err := os.Open(usageLedgerPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []usageRecord{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []usageRecord{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var record usageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", usageLedgerPath(), line, err)
+		}
+		if !record.Time.Before(since) {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,66 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
+	Use:   "list",
+	Short: "List the values in the config file",
+	Long: `List every value in the config file by its dotted key, with secrets masked.
+Use --show-secrets to print them in full. For example:
+
+  yoo config list`,
+	Run: func(cmd *cobra.Command, args []string) {
+		content, err := os.ReadFile(configFilePath())
+		checkError(err, "could not read config", true)
+		settings := map[string]any{}
+		checkError(yaml.Unmarshal(content, &settings), "could not parse "+configFilePath(), true)
+
+		flattenConfig([]string{}, settings, func(keys []string, value any) {
+			formatted := formatConfigValue(value)
+			if isSecretKey(keys) && !listShowSecrets {
+				formatted = maskSecret(formatted)
+			}
+			fmt.Println(joinConfigKey(keys) + " = " + formatted)
+		})
+	},
+}
+
+var listShowSecrets bool
+
+func init() {
+	configCmd.AddCommand(listCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// listCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	listCmd.Flags().BoolVar(&listShowSecrets, "show-secrets", false, "print secrets in full")
+}
@@ -0,0 +1,202 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const defaultLlamaCppEndpoint = "http://localhost:8080"
+
+// llamaCppProvider talks to the native API of a llama.cpp server. The server
+// only runs the model it was started with, so persona.Model is informational.
+type llamaCppProvider struct {
+	endpoint string
+	client   *http.Client
+}
+
+type llamaCppTemplateRequest struct {
+	Messages []ollamaMessage `json:"messages"`
+}
+
+type llamaCppTemplateResponse struct {
+	Prompt string `json:"prompt"`
+}
+
+type llamaCppCompletionRequest struct {
+	Prompt           string   `json:"prompt"`
+	Stream           bool     `json:"stream"`
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	NPredict         int      `json:"n_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+func newLlamaCppCompletionRequest(prompt string, params ModelParams) llamaCppCompletionRequest {
+	return llamaCppCompletionRequest{
+		Prompt:           prompt,
+		Temperature:      params.Temperature,
+		TopP:             params.TopP,
+		NPredict:         params.MaxTokens,
+		Stop:             params.Stop,
+		PresencePenalty:  params.PresencePenalty,
+		FrequencyPenalty: params.FrequencyPenalty,
+		Seed:             params.Seed,
+	}
+}
+
+type llamaCppCompletionResponse struct {
+	Content string `json:"content"`
+	Stop    bool   `json:"stop"`
+
+	TokensEvaluated int `json:"tokens_evaluated"`
+	TokensPredicted int `json:"tokens_predicted"`
+}
+
+func (r llamaCppCompletionResponse) usage() Usage {
+	return Usage{PromptTokens: r.TokensEvaluated, CompletionTokens: r.TokensPredicted}
+}
+
+type llamaCppModelsResponse struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+type llamaCppError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func newLlamaCppProvider(persona Persona) (Provider, error) {
+	return &llamaCppProvider{personaEndpoint(persona, defaultLlamaCppEndpoint), &http.Client{}}, nil
+}
+
+func (p *llamaCppProvider) Complete(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage) (string, Usage, error) {
+	prompt, err := p.applyTemplate(ctx, persona, history)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	var resp llamaCppCompletionResponse
+	err = p.do(ctx, http.MethodPost, "/completion", newLlamaCppCompletionRequest(prompt, persona.Params), &resp)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	return resp.Content, resp.usage(), nil
+}
+
+func (p *llamaCppProvider) Stream(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage, onDelta func(string)) (string, Usage, error) {
+	prompt, err := p.applyTemplate(ctx, persona, history)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	request := newLlamaCppCompletionRequest(prompt, persona.Params)
+	request.Stream = true
+	body, err := doStream(ctx, p.client, http.MethodPost, p.endpoint+"/completion", request)
+	if err != nil {
+		return "", Usage{}, p.withMessage(err)
+	}
+	defer body.Close()
+
+	// the reply arrives as server-sent events
+	var reply strings.Builder
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var chunk llamaCppCompletionResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return reply.String(), Usage{}, err
+		}
+		if chunk.Content != "" {
+			reply.WriteString(chunk.Content)
+			onDelta(chunk.Content)
+		}
+		if chunk.Stop {
+			return reply.String(), chunk.usage(), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return reply.String(), Usage{}, err
+	}
+	return reply.String(), Usage{}, io.ErrUnexpectedEOF
+}
+
+// applyTemplate lets the server format the conversation with the chat
+// template of its model.
+func (p *llamaCppProvider) applyTemplate(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage) (string, error) {
+	request := llamaCppTemplateRequest{}
+	for _, message := range persona.Messages(history) {
+		request.Messages = append(request.Messages, ollamaMessage{message.Role, message.Content})
+	}
+	var resp llamaCppTemplateResponse
+	if err := p.do(ctx, http.MethodPost, "/apply-template", request, &resp); err != nil {
+		return "", err
+	}
+	return resp.Prompt, nil
+}
+
+// Models lists the model the server was started with.
+func (p *llamaCppProvider) Models(ctx context.Context) ([]string, error) {
+	var resp llamaCppModelsResponse
+	if err := p.do(ctx, http.MethodGet, "/v1/models", nil, &resp); err != nil {
+		return nil, err
+	}
+	models := []string{}
+	for _, model := range resp.Data {
+		models = append(models, model.ID)
+	}
+	return models, nil
+}
+
+func (p *llamaCppProvider) do(ctx context.Context, method string, path string, body any, out any) error {
+	return p.withMessage(doJSON(ctx, p.client, method, p.endpoint+path, body, out))
+}
+
+// withMessage fills in the message of an http error from llama.cpp's error
+// body.
+func (p *llamaCppProvider) withMessage(err error) error {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		var apiErr llamaCppError
+		if json.Unmarshal(statusErr.Body, &apiErr) == nil && apiErr.Error.Message != "" {
+			statusErr.Message = apiErr.Error.Message
+		}
+	}
+	return err
+}
+
+func init() {
+	registerProvider("llamacpp", newLlamaCppProvider)
+}
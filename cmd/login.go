@@ -0,0 +1,106 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/andrew-d/go-termutil"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// loginCmd represents the login command
+var loginCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
+	Use:   "login",
+	Short: "Store the api key in the keyring or an encrypted file",
+	Long: `Store the api key in the system keyring, or with --store file in a file
+encrypted with a passphrase. The key is asked for without echoing it, or read
+from stdin. For example:
+
+  yoo auth login
+  pass show openai | yoo auth login --store file`,
+	Run: func(cmd *cobra.Command, args []string) {
+		key := ""
+		if termutil.Isatty(os.Stdin.Fd()) {
+			fmt.Print("api key: ")
+			input, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Println()
+			checkError(err, "problem reading stdin", true)
+			key = string(input)
+		} else {
+			input, err := stdinReader.ReadString('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				checkError(err, "problem reading stdin", true)
+			}
+			key = input
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			checkError(errors.New("no key given"), "could not log in", true)
+		}
+
+		switch loginStore {
+		case "keyring":
+			checkError(secretKeyring.Set(keyringService, openAIKeySecret, key), "could not store the key in the keyring, try --store file", true)
+		case "file":
+			secrets, err := readSecretsFile()
+			confirm := errors.Is(err, fs.ErrNotExist)
+			if confirm {
+				secrets = map[string]string{}
+			} else {
+				checkError(err, "could not read "+secretsFilePath(), true)
+			}
+			secrets[openAIKeySecret] = key
+			checkError(writeSecretsFile(secrets, confirm), "could not write "+secretsFilePath(), true)
+		default:
+			checkError(fmt.Errorf("unknown store %q", loginStore), "--store must be keyring or file", true)
+		}
+		if loginStore == "file" {
+			fmt.Println("stored the api key in " + secretsFilePath())
+		} else {
+			fmt.Println("stored the api key in the keyring")
+		}
+
+		if viper.GetString("secrets."+openAIKeySecret) != "" {
+			fmt.Println("the config still has the key in plaintext, remove it with `yoo config unset secrets." + openAIKeySecret + "`")
+		}
+	},
+}
+
+var loginStore string
+
+func init() {
+	authCmd.AddCommand(loginCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// loginCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	loginCmd.Flags().StringVar(&loginStore, "store", "keyring", "where to store the key: keyring or file")
+}
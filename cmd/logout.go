@@ -0,0 +1,81 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/spf13/cobra"
+	"github.com/zalando/go-keyring"
+)
+
+// logoutCmd represents the logout command
+var logoutCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
+	Use:   "logout",
+	Short: "Remove the api key from the keyring and encrypted file",
+	Long: `Remove the api key from the system keyring and the encrypted secrets file, or
+just one of them with --store. Keys in the environment, key-command or the
+config are left alone. For example:
+
+  yoo auth logout`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if logoutStore != "all" && logoutStore != "keyring" && logoutStore != "file" {
+			checkError(fmt.Errorf("unknown store %q", logoutStore), "--store must be all, keyring or file", true)
+		}
+
+		if logoutStore != "file" {
+			err := secretKeyring.Delete(keyringService, openAIKeySecret)
+			if err == nil {
+				fmt.Println("removed the api key from the keyring")
+			} else if !errors.Is(err, keyring.ErrNotFound) {
+				checkError(err, "could not remove the key from the keyring", logoutStore == "keyring")
+			}
+		}
+
+		if logoutStore != "keyring" {
+			secrets, err := readSecretsFile()
+			if errors.Is(err, fs.ErrNotExist) {
+				return
+			}
+			checkError(err, "could not read "+secretsFilePath(), true)
+			if _, ok := secrets[openAIKeySecret]; ok {
+				delete(secrets, openAIKeySecret)
+				checkError(writeSecretsFile(secrets, false), "could not write "+secretsFilePath(), true)
+				fmt.Println("removed the api key from " + secretsFilePath())
+			}
+		}
+	},
+}
+
+var logoutStore string
+
+func init() {
+	authCmd.AddCommand(logoutCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// logoutCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	logoutCmd.Flags().StringVar(&logoutStore, "store", "all", "where to remove the key from: all, keyring or file")
+}
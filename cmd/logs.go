@@ -0,0 +1,280 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// logEntry is a log file with what's known about it.
+type logEntry struct {
+	Path    string
+	Meta    LogMeta
+	Size    int64
+	ModTime time.Time
+}
+
+// Title is the log's title, or the one in its file name for logs that
+// can't be read.
+func (e logEntry) Title() string {
+	if e.Meta.Title != "" {
+		return e.Meta.Title
+	}
+	name := strings.TrimSuffix(filepath.Base(e.Path), ".md")
+	if _, title, ok := strings.Cut(name, "."); ok {
+		return title
+	}
+	return name
+}
+
+// Date is when the conversation started.
+func (e logEntry) Date() time.Time {
+	if !e.Meta.Started.IsZero() {
+		return e.Meta.Started
+	}
+	return e.ModTime
+}
+
+// logFiles stats the logs in the log directory, most recently written first,
+// which is what newest means everywhere logs are numbered or picked.
+func logFiles() ([]os.FileInfo, error) {
+	dirEntries, err := os.ReadDir(viper.GetString("logpath"))
+	if err != nil {
+		return nil, err
+	}
+	infos := []os.FileInfo{}
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil || !isLogFile(info) {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		if !infos[i].ModTime().Equal(infos[j].ModTime()) {
+			return infos[i].ModTime().After(infos[j].ModTime())
+		}
+		return infos[i].Name() > infos[j].Name()
+	})
+	return infos, nil
+}
+
+// listLogs reads every log in the log directory, newest first.
+func listLogs() ([]logEntry, error) {
+	infos, err := logFiles()
+	if err != nil {
+		return nil, err
+	}
+	logPath := viper.GetString("logpath")
+	entries := []logEntry{}
+	for _, info := range infos {
+		entry := logEntry{Path: filepath.Join(logPath, info.Name()), Size: info.Size(), ModTime: info.ModTime()}
+		// a log that can't be parsed is still listed, by its file name
+		if l, err := readChatLog(entry.Path); err == nil {
+			entry.Meta = l.Meta
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// logFilter narrows logs by persona and date.
+type logFilter struct {
+	persona string
+	since   string
+	until   string
+	days    int
+}
+
+func (f logFilter) apply(entries []logEntry) ([]logEntry, error) {
+	var since, until time.Time
+	if f.days > 0 {
+		since = time.Now().Local().AddDate(0, 0, -f.days)
+	}
+	for _, bound := range []struct {
+		value string
+		into  *time.Time
+		add   int
+	}{{f.since, &since, 0}, {f.until, &until, 1}} {
+		if bound.value == "" {
+			continue
+		}
+		parsed, err := time.ParseInLocation("2006-01-02", bound.value, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("dates must look like 2023-04-01: %w", err)
+		}
+		// --until includes the whole day
+		*bound.into = parsed.AddDate(0, 0, bound.add)
+	}
+
+	filtered := []logEntry{}
+	for _, entry := range entries {
+		if f.persona != "" && !strings.EqualFold(entry.Meta.Persona, f.persona) {
+			continue
+		}
+		if !since.IsZero() && entry.Date().Before(since) {
+			continue
+		}
+		if !until.IsZero() && !entry.Date().Before(until) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered, nil
+}
+
+// logNumber is how logs are referred to by `yoo peep <n>`: 1 is the newest.
+func logNumber(entries []logEntry, entry logEntry) int {
+	for i, e := range entries {
+		if e.Path == entry.Path {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// formatSize prints a byte count the way ls -h would.
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", size)
+	}
+}
+
+// highlighter styles text with an ansi code, or leaves it alone when the
+// output isn't a terminal.
+func highlighter(color bool) func(text string, code string) string {
+	return func(text string, code string) string {
+		if !color {
+			return text
+		}
+		return "\x1b[" + code + "m" + text + "\x1b[0m"
+	}
+}
+
+// pickLog lets the user choose one of entries, with fzf if it's installed
+// and a prompt that narrows the list otherwise.
+func pickLog(entries []logEntry) (logEntry, error) {
+	if len(entries) == 0 {
+		return logEntry{}, errors.New("no logs to pick from")
+	}
+	lines := []string{}
+	for i, entry := range entries {
+		lines = append(lines, fmt.Sprintf("%d  %s  %s  %s", i+1, entry.Date().Format("2006-01-02 15:04"), entry.Meta.Persona, entry.Title()))
+	}
+
+	if _, err := exec.LookPath("fzf"); err == nil {
+		cmd := exec.Command("fzf", "--no-sort", "--prompt", "log ≫ ")
+		cmd.Stdin = strings.NewReader(strings.Join(lines, "\n"))
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return logEntry{}, errors.New("nothing picked")
+		}
+		n, _ := strconv.Atoi(strings.Fields(string(output))[0])
+		return entries[n-1], nil
+	}
+
+	// without fzf, typing narrows the list to lines containing the letters
+	// typed in order, and a number picks that log
+	matching := lines
+	query := ""
+	for {
+		for i, line := range matching {
+			if i == 20 {
+				fmt.Printf("... and %d more\n", len(matching)-20)
+				break
+			}
+			fmt.Println(line)
+		}
+		fmt.Print("\nfilter, or number to open (enter opens the first) ≫ ")
+		input, err := stdinReader.ReadString('\n')
+		if err != nil {
+			return logEntry{}, errors.New("nothing picked")
+		}
+		input = strings.TrimSpace(input)
+		if input == "" && len(matching) > 0 {
+			input = strings.Fields(matching[0])[0]
+		}
+		if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(entries) {
+			return entries[n-1], nil
+		}
+		query = input
+		matching = []string{}
+		for _, line := range lines {
+			if fuzzyMatch(line, query) {
+				matching = append(matching, line)
+			}
+		}
+		if len(matching) == 0 {
+			fmt.Println("no logs match " + query)
+			matching = lines
+		}
+		fmt.Println()
+	}
+}
+
+// fuzzyMatch reports whether the letters of query appear in text in order,
+// ignoring case.
+func fuzzyMatch(text string, query string) bool {
+	text, query = strings.ToLower(text), strings.ToLower(query)
+	for _, r := range query {
+		i := strings.IndexRune(text, r)
+		if i == -1 {
+			return false
+		}
+		text = text[i+len(string(r)):]
+	}
+	return true
+}
+
+// pagerCommand is the `pager` setting split into its command and arguments,
+// so `less -R` works, or less when it's unset.
+func pagerCommand() []string {
+	pager := viper.GetString("pager")
+	if pager == "" {
+		pager = "less" // fallback
+	}
+	return strings.Fields(pager)
+}
+
+// openInPager shows a file in the configured pager.
+func openInPager(path string) error {
+	pager := pagerCommand()
+	if len(pager) == 0 {
+		return errors.New("the pager setting is blank")
+	}
+	command := exec.Command(pager[0], append(pager[1:], path)...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	return command.Run()
+}
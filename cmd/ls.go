@@ -0,0 +1,68 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
+	Use:   "ls",
+	Short: "List logs",
+	Long: `List logs, newest first, numbered for yoo peep <n>. For example:
+
+  yoo peep ls
+  yoo peep ls --persona archie --since 2023-04-01`,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := listLogs()
+		checkError(err, "could not list logs", true)
+		filtered, err := lsFilter.apply(entries)
+		checkError(err, "could not filter logs", true)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "#\tDATE\tPERSONA\tSIZE\tTITLE")
+		for _, entry := range filtered {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", logNumber(entries, entry), entry.Date().Format("2006-01-02 15:04"), entry.Meta.Persona, formatSize(entry.Size), entry.Title())
+		}
+		w.Flush()
+	},
+}
+
+var lsFilter logFilter
+
+func init() {
+	peepCmd.AddCommand(lsCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// lsCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	lsCmd.Flags().StringVar(&lsFilter.persona, "persona", "", "only list logs with this persona")
+	lsCmd.Flags().StringVar(&lsFilter.since, "since", "", "only list logs from this date on (YYYY-MM-DD)")
+	lsCmd.Flags().StringVar(&lsFilter.until, "until", "", "only list logs up to this date (YYYY-MM-DD)")
+	lsCmd.Flags().IntVar(&lsFilter.days, "days", 0, "only list logs from the last number of days")
+}
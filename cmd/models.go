@@ -0,0 +1,85 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// modelsCmd represents the models command
+var modelsCmd = &cobra.Command{
+	Args:  cobra.MaximumNArgs(1),
+	Use:   "models [persona]",
+	Short: "List the models served by a persona's provider",
+	Long: `List the models served by the provider of a persona (the default persona if
+none is given). The model the persona is configured with is marked with *.
+
+For example:
+
+  yoo models
+  yoo models llama`,
+	Run: func(cmd *cobra.Command, args []string) {
+		personaName := viper.GetString("persona")
+		if len(args) > 0 {
+			personaName = args[0]
+		}
+		persona, err := loadPersona(personaName)
+		checkError(err, "could not load persona", true)
+		provider, err := providerFor(persona)
+		checkError(err, "could not set up provider for "+persona.Name, true)
+
+		lister, ok := provider.(ModelLister)
+		if !ok {
+			fmt.Println("the provider for " + persona.Name + " can't list its models")
+			return
+		}
+		models, err := lister.Models(context.Background())
+		checkError(err, "could not list models for "+persona.Name, true)
+
+		found := false
+		for _, model := range models {
+			marker := "  "
+			if model == persona.Model || strings.TrimSuffix(model, ":latest") == persona.Model {
+				marker = "* "
+				found = true
+			}
+			fmt.Println(marker + model)
+		}
+		if !found && persona.Model != "" && !viper.GetBool("quiet") {
+			fmt.Println("\n" + persona.Name + " uses " + persona.Model + ", which isn't in this list")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(modelsCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// modelsCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// modelsCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
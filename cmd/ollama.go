@@ -0,0 +1,237 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const defaultOllamaEndpoint = "http://localhost:11434"
+
+// ollamaProvider talks to the native API of an Ollama server.
+type ollamaProvider struct {
+	endpoint string
+	client   *http.Client
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+type ollamaOptions struct {
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	NumPredict       int      `json:"num_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+func newOllamaOptions(params ModelParams) ollamaOptions {
+	return ollamaOptions{
+		Temperature:      params.Temperature,
+		TopP:             params.TopP,
+		NumPredict:       params.MaxTokens,
+		Stop:             params.Stop,
+		PresencePenalty:  params.PresencePenalty,
+		FrequencyPenalty: params.FrequencyPenalty,
+		Seed:             params.Seed,
+	}
+}
+
+type ollamaChatResponse struct {
+	Model   string        `json:"model"`
+	Message ollamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+	Error   string        `json:"error"`
+
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+func (r ollamaChatResponse) usage() Usage {
+	return Usage{PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount}
+}
+
+type ollamaTagsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+type ollamaError struct {
+	Error string `json:"error"`
+}
+
+func newOllamaProvider(persona Persona) (Provider, error) {
+	fallback := os.Getenv("OLLAMA_HOST")
+	if fallback == "" {
+		fallback = defaultOllamaEndpoint
+	}
+	return &ollamaProvider{personaEndpoint(persona, fallback), &http.Client{}}, nil
+}
+
+func (p *ollamaProvider) Complete(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage) (string, Usage, error) {
+	request := p.chatRequest(persona, history)
+
+	var resp ollamaChatResponse
+	err := p.do(ctx, http.MethodPost, "/api/chat", request, &resp)
+	if err != nil {
+		return "", Usage{}, p.chatError(ctx, persona, err)
+	}
+	return resp.Message.Content, resp.usage(), nil
+}
+
+func (p *ollamaProvider) Stream(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage, onDelta func(string)) (string, Usage, error) {
+	request := p.chatRequest(persona, history)
+	request.Stream = true
+
+	body, err := doStream(ctx, p.client, http.MethodPost, p.endpoint+"/api/chat", request)
+	if err != nil {
+		return "", Usage{}, p.chatError(ctx, persona, p.withMessage(err))
+	}
+	defer body.Close()
+
+	// the reply arrives as one json object per line
+	var reply strings.Builder
+	decoder := json.NewDecoder(body)
+	for {
+		var chunk ollamaChatResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return reply.String(), Usage{}, err
+		}
+		if chunk.Error != "" {
+			return reply.String(), Usage{}, errors.New(chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			reply.WriteString(chunk.Message.Content)
+			onDelta(chunk.Message.Content)
+		}
+		if chunk.Done {
+			return reply.String(), chunk.usage(), nil
+		}
+	}
+}
+
+func (p *ollamaProvider) chatRequest(persona Persona, history []openai.ChatCompletionMessage) ollamaChatRequest {
+	request := ollamaChatRequest{
+		Model:   persona.Model,
+		Options: newOllamaOptions(persona.Params),
+	}
+	for _, message := range persona.Messages(history) {
+		request.Messages = append(request.Messages, ollamaMessage{message.Role, message.Content})
+	}
+	return request
+}
+
+// chatError swaps a 404 from /api/chat for a hint about pulling the model.
+func (p *ollamaProvider) chatError(ctx context.Context, persona Persona, err error) error {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return p.modelNotFound(ctx, persona.Model)
+	}
+	return err
+}
+
+// Models lists the models that have been pulled on the server.
+func (p *ollamaProvider) Models(ctx context.Context) ([]string, error) {
+	var resp ollamaTagsResponse
+	if err := p.do(ctx, http.MethodGet, "/api/tags", nil, &resp); err != nil {
+		return nil, err
+	}
+	models := []string{}
+	for _, model := range resp.Models {
+		models = append(models, model.Name)
+	}
+	return models, nil
+}
+
+// Embed embeds texts with a pulled embedding model.
+func (p *ollamaProvider) Embed(ctx context.Context, model string, texts []string) ([][]float32, Usage, error) {
+	var resp ollamaEmbedResponse
+	err := p.do(ctx, http.MethodPost, "/api/embed", ollamaEmbedRequest{model, texts}, &resp)
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, Usage{}, p.modelNotFound(ctx, model)
+	}
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, Usage{}, fmt.Errorf("asked for %d embeddings but got %d", len(texts), len(resp.Embeddings))
+	}
+	return resp.Embeddings, Usage{PromptTokens: resp.PromptEvalCount}, nil
+}
+
+func (p *ollamaProvider) modelNotFound(ctx context.Context, model string) error {
+	message := fmt.Sprintf("model %q is not available on %s, pull it with `ollama pull %s`", model, p.endpoint, model)
+	if models, err := p.Models(ctx); err == nil && len(models) > 0 {
+		message += " (pulled models: " + strings.Join(models, ", ") + ")"
+	}
+	return errors.New(message)
+}
+
+func (p *ollamaProvider) do(ctx context.Context, method string, path string, body any, out any) error {
+	return p.withMessage(doJSON(ctx, p.client, method, p.endpoint+path, body, out))
+}
+
+// withMessage fills in the message of an http error from ollama's error body.
+func (p *ollamaProvider) withMessage(err error) error {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		var apiErr ollamaError
+		if json.Unmarshal(statusErr.Body, &apiErr) == nil && apiErr.Error != "" {
+			statusErr.Message = apiErr.Error
+		}
+	}
+	return err
+}
+
+func init() {
+	registerProvider("ollama", newOllamaProvider)
+}
@@ -17,75 +17,73 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"time"
+	"strconv"
+	"strings"
 
+	termutil "github.com/andrew-d/go-termutil"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 // peepCmd represents the peep command
 var peepCmd = &cobra.Command{
-	Use:   "peep",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Args:  cobra.MaximumNArgs(1),
+	Use:   "peep [n|title]",
+	Short: "Open a log in your pager",
+	Long: `Open a log in your pager: the nth newest (as numbered by yoo peep ls), or the
+newest whose name contains some text. With nothing given, pick one from a list
+when the output is a terminal (with fzf if it's installed), otherwise open the
+newest. For example:
+
+  yoo peep
+  yoo peep 3
+  yoo peep pacman`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// todo: search, list
-		// for now: return latest
-		latest := getLatest()
-		pager := viper.GetString("pager")
-		if pager == "" {
-			pager = "less" // fallback
+		interactive := termutil.Isatty(os.Stdout.Fd()) && termutil.Isatty(os.Stdin.Fd())
+		var path string
+		if len(args) == 0 {
+			if interactive {
+				entries, err := listLogs()
+				checkError(err, "could not list logs", true)
+				entry, err := pickLog(entries)
+				checkError(err, "no log opened", true)
+				path = entry.Path
+			} else {
+				path = getLatest()
+			}
+		} else if n, err := strconv.Atoi(args[0]); err == nil {
+			entries, err := listLogs()
+			checkError(err, "could not list logs", true)
+			if n < 1 || n > len(entries) {
+				checkError(fmt.Errorf("there are %d logs", len(entries)), "no log number "+args[0], true)
+			}
+			path = entries[n-1].Path
+		} else {
+			path, err = findLog(args[0])
+			checkError(err, "could not find log", true)
 		}
-		command := exec.Command(pager, latest)
-		command.Stdout = os.Stdout
-		command.Stderr = os.Stderr
-
-		err := command.Start()
-		checkError(err, "couldn't start pager command", true)
-		err = command.Wait()
-		checkError(err, "couldn't run pager command", true)
+		checkError(openInPager(path), "couldn't run pager command", true)
 	},
 }
 
+// getLatest is the path of the newest log, the first one yoo peep ls lists.
+// It only stats the logs rather than reading them, to keep chat last quick.
 func getLatest() string {
-	logPath := viper.GetString("logpath")
-	var latestFile os.FileInfo
-	var latestTime time.Time
-
-	err := filepath.Walk(logPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// If not a directory and file modification time is greater
-		// than the current latest time, update the latest file
-		if !info.IsDir() && info.ModTime().After(latestTime) {
-			latestFile = info
-			latestTime = info.ModTime()
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		log.Fatal("Error walking the directory:", err)
-	}
-
-	if latestFile != nil {
-		return logPath + latestFile.Name()
-	} else {
+	infos, err := logFiles()
+	checkError(err, "could not list logs", true)
+	if len(infos) == 0 {
 		log.Fatal("No files found in the directory.")
 	}
-	return ""
+	return filepath.Join(viper.GetString("logpath"), infos[0].Name())
+}
+
+// isLogFile tells logs apart from the ledger and other files kept with them.
+func isLogFile(info os.FileInfo) bool {
+	return !info.IsDir() && filepath.Ext(info.Name()) == ".md" && !strings.HasPrefix(info.Name(), ".")
 }
 
 func init() {
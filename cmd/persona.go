@@ -18,52 +18,106 @@ package cmd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 // personaCmd represents the persona command
 var personaCmd = &cobra.Command{
 	Args:  cobra.MaximumNArgs(1),
-	Use:   "persona",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Use:   "persona [name]",
+	Short: "Create or edit a persona",
+	Long: `Create or edit a persona. Without a name, print the default persona.
+
+You are asked for the persona's model, then its system prompt opens in $VISUAL
+or $EDITOR (or vim). Use --set-model and --set-system to skip the questions:
+
+  yoo config persona archie
+  yoo config persona archie --set-model gpt-4 --set-system "you are an expert Arch user"`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			fmt.Println(viper.GetString("persona"))
 			return
 		} else {
 			persona := args[0]
+			stubTemplateHelpers = true
+			interactive := !cmd.Flags().Changed("set-model") && !cmd.Flags().Changed("set-system")
 			// if persona doesn't exist, ask if should create
 			// bail if no
-			if viper.GetString("personas."+persona)+".model" == "" {
+			exists := personaExists(persona)
+			if !exists && interactive {
 				fmt.Println("persona [" + persona + "] doesn't exist. add it?")
 				if !confirmWithUser() {
 					return
 				}
 			}
+
 			// confirm existing model, or add new model
-			// model := "gpt-4"
+			// a new persona made with just --set-system gets the model it
+			// would have been offered
+			model := personaSetModel
+			if interactive || (!exists && model == "") {
+				model = "gpt-4"
+				if loaded, err := loadPersona(persona); err == nil && loaded.Model != "" {
+					model = loaded.Model
+				}
+				if interactive {
+					model = askWithDefault("model", model)
+				}
+			}
+			if model != "" {
+				checkError(setPersonaModel(persona, model), "could not set model for "+persona, true)
+			}
+
 			// open system message in editor w vim fallback
+			_, system, path, err := readPersonaFile(persona)
+			if errors.Is(err, fs.ErrNotExist) {
+				path = viper.GetString("configpath") + persona + ".txt"
+			} else {
+				checkError(err, "could not read persona "+persona, true)
+			}
+			if cmd.Flags().Changed("set-system") {
+				system = personaSetSystem
+			}
+			if !exists || cmd.Flags().Changed("set-system") {
+				checkError(writePersonaSystem(path, system), "could not write system prompt for "+persona, true)
+			}
+			if interactive {
+				checkError(openInEditor(path), "could not edit "+path, true)
+			}
+			_, err = loadPersona(persona)
+			checkError(err, "persona "+persona+" is invalid", true)
+			if !viper.GetBool("quiet") {
+				fmt.Println("saved persona " + persona)
+			}
 			return
 		}
 	},
 }
 
+var (
+	personaSetModel  string
+	personaSetSystem string
+)
+
+// stdinReader is shared by everything that asks the user questions, so no
+// input is lost to another reader's buffer.
+var stdinReader = bufio.NewReader(os.Stdin)
+
 func confirmWithUser() bool {
-	reader := bufio.NewReader(os.Stdin)
 	for {
 		fmt.Print("\n≫ ")
-		userPrompt, err := reader.ReadString('\n')
+		userPrompt, err := stdinReader.ReadString('\n')
 		checkError(err, "problem reading stdin", true)
 		userPrompt = strings.TrimSpace(userPrompt)
 		if userPrompt == "quit" || userPrompt == "exit" || userPrompt == "n" || userPrompt == "N" {
@@ -75,6 +129,101 @@ func confirmWithUser() bool {
 		}
 	}
 }
+
+// askWithDefault asks the user a question, returning fallback if they just
+// press enter.
+func askWithDefault(question string, fallback string) string {
+	fmt.Print(question + " [" + fallback + "] ≫ ")
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		checkError(err, "problem reading stdin", true)
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return fallback
+	}
+	return answer
+}
+
+// setPersonaModel stores a persona's model where it will be read from: the
+// front matter of its persona file if it sets one there, else the config.
+// The prompt after the front matter is kept as it is.
+func setPersonaModel(persona string, model string) error {
+	frontMatter, _, path, err := readPersonaFile(persona)
+	if err == nil && frontMatter != "" {
+		var meta personaFile
+		if err := yaml.Unmarshal([]byte(frontMatter), &meta); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if meta.Model != "" {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(frontMatter), &doc); err != nil {
+				return err
+			}
+			var value yaml.Node
+			value.SetString(model)
+			if err := setYAMLValue(doc.Content[0], []string{"model"}, &value); err != nil {
+				return err
+			}
+			encoded, err := encodeYAML(&doc)
+			if err != nil {
+				return err
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			newline := "\n"
+			if strings.HasPrefix(string(content), "---\r\n") {
+				newline = "\r\n"
+			}
+			delimiter := "---" + newline
+			rest := newline + strings.TrimPrefix(string(content), delimiter)
+			_, body, _ := strings.Cut(rest, newline+delimiter)
+			encoded = []byte(strings.ReplaceAll(string(encoded), "\n", newline))
+			return os.WriteFile(path, []byte(delimiter+string(encoded)+delimiter+body), 0644)
+		}
+	}
+	return setConfigValue([]string{"personas", persona, "model"}, model)
+}
+
+// writePersonaSystem replaces the system prompt in a persona file, keeping
+// any front matter.
+func writePersonaSystem(path string, system string) error {
+	content := system
+	if existing, err := os.ReadFile(path); err == nil {
+		if rest, ok := strings.CutPrefix(string(existing), "---\n"); ok {
+			if frontMatter, _, ok := strings.Cut(rest, "\n---\n"); ok {
+				content = "---\n" + frontMatter + "\n---\n" + system
+			}
+		}
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// openInEditor opens a file in $VISUAL or $EDITOR, falling back to vim.
+func openInEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vim"
+	}
+	// editors are often set with arguments, e.g. "code --wait"
+	args := append(strings.Fields(editor), path)
+	command := exec.Command(args[0], args[1:]...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	return command.Run()
+}
+
 func init() {
 	configCmd.AddCommand(personaCmd)
 
@@ -86,5 +235,6 @@ func init() {
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
-	// personaCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	personaCmd.Flags().StringVar(&personaSetModel, "set-model", "", "set the persona's model without asking")
+	personaCmd.Flags().StringVar(&personaSetSystem, "set-system", "", "set the persona's system prompt without opening an editor")
 }
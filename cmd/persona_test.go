@@ -0,0 +1,70 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSetPersonaModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"keeps the blank line", "---\nmodel: gpt-3.5-turbo\n---\n\nyou are archie\n", "---\nmodel: gpt-4\n---\n\nyou are archie\n"},
+		{"keeps the prompt as it is", "---\nmodel: gpt-3.5-turbo\ntemperature: 0\n---\nbefore\n---\nafter", "---\nmodel: gpt-4\ntemperature: 0\n---\nbefore\n---\nafter"},
+		{"crlf", "---\r\nmodel: gpt-3.5-turbo\r\n---\r\n\r\nyou are archie\r\n", "---\r\nmodel: gpt-4\r\n---\r\n\r\nyou are archie\r\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := testConfig(t, nil)
+			if err := os.WriteFile(dir+"archie.md", []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := setPersonaModel("archie", "gpt-4"); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := os.ReadFile(dir + "archie.md")
+			if string(got) != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPersonaCmdDefaultsTheModel(t *testing.T) {
+	dir := testConfig(t, nil)
+	if err := personaCmd.Flags().Set("set-system", "you are archie"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		personaSetSystem, stubTemplateHelpers = "", false
+		personaCmd.Flags().Lookup("set-system").Changed = false
+	})
+
+	personaCmd.Run(personaCmd, []string{"archie"})
+	if got := viper.GetString("personas.archie.model"); got != "gpt-4" {
+		t.Errorf("model is %q, want gpt-4", got)
+	}
+	if got, _ := os.ReadFile(dir + "archie.txt"); string(got) != "you are archie\n" {
+		t.Errorf("system prompt is %q", got)
+	}
+}
@@ -17,7 +17,14 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -25,24 +32,79 @@ import (
 
 // personasCmd represents the personas command
 var personasCmd = &cobra.Command{
-	Use:   "personas",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Args:    cobra.NoArgs,
+	Use:     "personas",
+	Aliases: []string{"persona"},
+	Short:   "List personas and where they come from",
+	Long: `List every persona, with its model, the file its system prompt is read from
+and the config that sets it up. Personas in a project's .yoo/ directory win
+over your own. The default persona is marked with *. For example:
+
+  yoo personas`,
 	Run: func(cmd *cobra.Command, args []string) {
-		personas := viper.GetStringMap("personas")
-		for key := range personas {
-			fmt.Println(key)
+		names := map[string]bool{}
+		for name := range viper.GetStringMap("personas") {
+			names[name] = true
+		}
+		for _, dir := range personaDirs() {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, entry := range entries {
+				ext := filepath.Ext(entry.Name())
+				if !entry.IsDir() && (ext == ".md" || ext == ".txt") {
+					names[strings.TrimSuffix(entry.Name(), ext)] = true
+				}
+			}
 		}
+		sorted := []string{}
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  NAME\tMODEL\tFILE\tCONFIG")
+		for _, name := range sorted {
+			marker := "  "
+			if name == viper.GetString("persona") {
+				marker = "* "
+			}
+			model := "-"
+			file := "-"
+			// resolved rather than loaded, so templates aren't rendered
+			if persona, err := resolvePersona(name, nil); err == nil {
+				model = persona.Model
+				_, _, path, _ := readPersonaFile(name)
+				file = shortPath(path)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				model = "(invalid: " + err.Error() + ")"
+			}
+			config := "-"
+			if source := personaConfigSource(name); source != "" {
+				config = shortPath(source)
+			}
+			fmt.Fprintln(w, marker+name+"\t"+model+"\t"+file+"\t"+config)
+		}
+		w.Flush()
 	},
 }
 
+// shortPath shows a path relative to the home directory as ~/...
+func shortPath(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	if rest, ok := strings.CutPrefix(path, home+string(filepath.Separator)); ok {
+		return "~/" + rest
+	}
+	return path
+}
+
 func init() {
-	peepCmd.AddCommand(personasCmd)
+	rootCmd.AddCommand(personasCmd)
 
 	// Here you will define your flags and configuration settings.
 
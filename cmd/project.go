@@ -0,0 +1,299 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// projectDirName is the directory a project keeps its own config.yml and
+// personas/ in.
+const projectDirName = ".yoo"
+
+// projectIgnoredKeys can only be set in the user config, at the top, under
+// personas.<name> or under models.<model>. They run commands, decide where
+// secrets and logs go or how much you're willing to spend, which a cloned
+// repo shouldn't get to choose.
+var projectIgnoredKeys = []string{"secrets", "secrets-file", "key-command", "pager", "usage-path", "template-helpers", "trusted-projects", "budget", "price"}
+
+// projectTrustedKeys decide where prompts go, and the api key with them, or
+// what else is sent along. A project only gets to set them once you trust it
+// with `yoo project trust`.
+var projectTrustedKeys = []string{"base-url", "endpoint", "headers", "api-type", "api-version", "org-id", "deployment", "provider", "embedding-persona", "embedding-model", "recall", "knowledge"}
+
+// projectTrustedPersonaKeys are the projectTrustedKeys of personas.<name>.
+// They read files into the system prompt or put words in the model's mouth,
+// and a project can set them on your own personas as well as its own.
+var projectTrustedPersonaKeys = []string{"include", "extends", "examples"}
+
+// projectTrustedFrontMatter are the projectTrustedKeys a persona file in a
+// project can set in its front matter. Its includes and knowledge are kept
+// inside the project instead.
+var projectTrustedFrontMatter = []string{"provider"}
+
+// project is a .yoo directory found above the working directory.
+type project struct {
+	Dir string
+	// Personas are the personas its config.yml sets, with the keys it sets
+	// for each.
+	Personas map[string]map[string]bool
+	// Trusted is set when the project is in `trusted-projects`.
+	Trusted bool
+	// Dropped are the keys in its config.yml that were ignored.
+	Dropped []string
+}
+
+// Root is the directory the project's .yoo is in.
+func (p project) Root() string {
+	return filepath.Dir(p.Dir)
+}
+
+// projects are the projects in use, nearest first.
+var projects []project
+
+// findProjects walks up from the working directory collecting .yoo
+// directories with a config.yml or personas/ in them, nearest first. The
+// log directory, ~/.yoo, is not a project.
+func findProjects() []string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	logPath := filepath.Clean(viper.GetString("logpath"))
+	found := []string{}
+	for {
+		candidate := filepath.Join(dir, projectDirName)
+		if candidate != logPath && (fileExists(filepath.Join(candidate, "config.yml")) || fileExists(filepath.Join(candidate, "personas"))) {
+			found = append(found, candidate)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return found
+		}
+		dir = parent
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readConfig reads the user config and merges the config of every project
+// over it, nearer projects winning.
+func readConfig() error {
+	err := viper.ReadInConfig()
+
+	projects = []project{}
+	for _, dir := range findProjects() {
+		projects = append(projects, project{Dir: dir, Personas: map[string]map[string]bool{}, Trusted: isTrustedProject(filepath.Dir(dir))})
+	}
+	for i := len(projects) - 1; i >= 0; i-- {
+		settings, mergeErr := readProjectConfig(&projects[i])
+		if mergeErr == nil {
+			mergeErr = viper.MergeConfigMap(settings)
+		}
+		if mergeErr != nil {
+			fmt.Fprintln(os.Stderr, "could not load project config in "+projects[i].Dir+": "+mergeErr.Error())
+		}
+	}
+	return err
+}
+
+// readProjectConfig reads a project's config.yml without the keys projects
+// aren't allowed to set, or aren't allowed to set until they're trusted.
+func readProjectConfig(p *project) (map[string]any, error) {
+	path := filepath.Join(p.Dir, "config.yml")
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]any{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]any{}
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return nil, err
+	}
+
+	ignore := func(settings map[string]any, prefix string, trustedKeys []string) {
+		for _, key := range projectIgnoredKeys {
+			if _, ok := settings[key]; ok {
+				delete(settings, key)
+				p.Dropped = append(p.Dropped, prefix+key)
+				fmt.Fprintln(os.Stderr, "ignoring "+prefix+key+" in "+path+", it can only be set in "+configFilePath())
+			}
+		}
+		if p.Trusted {
+			return
+		}
+		for _, key := range trustedKeys {
+			if _, ok := settings[key]; ok {
+				delete(settings, key)
+				p.Dropped = append(p.Dropped, prefix+key)
+				fmt.Fprintln(os.Stderr, "ignoring "+prefix+key+" in "+path+" until you trust the project with `yoo project trust`")
+			}
+		}
+	}
+	ignore(settings, "", projectTrustedKeys)
+	if personas, ok := settings["personas"].(map[string]any); ok {
+		for name, persona := range personas {
+			keys := map[string]bool{}
+			if persona, ok := persona.(map[string]any); ok {
+				ignore(persona, "personas."+name+".", append(projectTrustedKeys, projectTrustedPersonaKeys...))
+				for key := range persona {
+					keys[strings.ToLower(key)] = true
+				}
+			}
+			p.Personas[strings.ToLower(name)] = keys
+		}
+	}
+	if models, ok := settings["models"].(map[string]any); ok {
+		for name, model := range models {
+			if model, ok := model.(map[string]any); ok {
+				ignore(model, "models."+name+".", nil)
+			}
+		}
+	}
+	return settings, nil
+}
+
+// isTrustedProject reports whether root is in `trusted-projects`.
+func isTrustedProject(root string) bool {
+	for _, trusted := range viper.GetStringSlice("trusted-projects") {
+		if filepath.Clean(expandHome(trusted)) == filepath.Clean(root) {
+			return true
+		}
+	}
+	return false
+}
+
+// projectFrontMatter drops projectTrustedFrontMatter from the front matter of
+// a persona file in a project that isn't trusted, like readProjectConfig does
+// for its config.yml.
+func projectFrontMatter(systemfile string, frontMatter string) (string, error) {
+	p, ok := projectOf(systemfile)
+	if !ok || p.Trusted || frontMatter == "" {
+		return frontMatter, nil
+	}
+	settings := map[string]any{}
+	if err := yaml.Unmarshal([]byte(frontMatter), &settings); err != nil {
+		return "", fmt.Errorf("%s: %w", systemfile, err)
+	}
+	dropped := false
+	for _, key := range projectTrustedFrontMatter {
+		if _, ok := settings[key]; ok {
+			delete(settings, key)
+			dropped = true
+			fmt.Fprintln(os.Stderr, "ignoring "+key+" in "+systemfile+" until you trust the project with `yoo project trust`")
+		}
+	}
+	if !dropped {
+		return frontMatter, nil
+	}
+	encoded, err := yaml.Marshal(settings)
+	return string(encoded), err
+}
+
+// projectOf returns the project whose .yoo directory path is in.
+func projectOf(path string) (project, bool) {
+	for _, p := range projects {
+		if strings.HasPrefix(filepath.Clean(path), p.Dir+string(filepath.Separator)) {
+			return p, true
+		}
+	}
+	return project{}, false
+}
+
+// projectUnder returns the innermost project whose root path is in, which
+// takes in the files next to its .yoo as well as the ones inside it.
+func projectUnder(path string) (project, bool) {
+	for _, p := range projects {
+		if strings.HasPrefix(filepath.Clean(path), p.Root()+string(filepath.Separator)) {
+			return p, true
+		}
+	}
+	return project{}, false
+}
+
+// projectPath resolves a path in a project persona against base, refusing
+// anything that leads outside the project: absolute paths, ~/, any .. and
+// symlinks pointing out of it.
+func projectPath(p project, base string, path string) (string, error) {
+	outside := fmt.Errorf("%s leads outside %s, project personas can only refer to files inside their project", path, shortPath(p.Root()))
+	if strings.HasPrefix(path, "~") || filepath.IsAbs(path) {
+		return "", outside
+	}
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == ".." {
+			return "", outside
+		}
+	}
+	joined := filepath.Join(base, path)
+	root, err := filepath.EvalSymlinks(p.Root())
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(joined); err == nil && real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return "", outside
+	}
+	return joined, nil
+}
+
+// personaDirs are the directories persona files are looked for in, in order:
+// the personas/ of each project, nearest first, then the user's config
+// directory.
+func personaDirs() []string {
+	dirs := []string{}
+	for _, p := range projects {
+		dirs = append(dirs, filepath.Join(p.Dir, "personas")+string(filepath.Separator))
+	}
+	return append(dirs, viper.GetString("configpath"))
+}
+
+// personaConfigSource says where personas.<name> is set: the nearest
+// project that sets it, the user config, or nowhere.
+func personaConfigSource(name string) string {
+	for _, p := range projects {
+		if _, ok := p.Personas[strings.ToLower(name)]; ok {
+			return filepath.Join(p.Dir, "config.yml")
+		}
+	}
+	if viper.IsSet("personas." + name) {
+		return configFilePath()
+	}
+	return ""
+}
+
+// personaSettingProject returns the nearest project whose config.yml sets
+// personas.<name>.<key>, which is where its value comes from.
+func personaSettingProject(name string, key string) (project, bool) {
+	for _, p := range projects {
+		if p.Personas[strings.ToLower(name)][key] {
+			return p, true
+		}
+	}
+	return project{}, false
+}
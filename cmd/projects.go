@@ -0,0 +1,91 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// projectCmd represents the project command
+var projectCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
+	Use:   "project",
+	Short: "List the projects in use and whether they're trusted",
+	Long: `List the .yoo directories found from here up, nearest first, whether each is
+trusted and the keys in its config.yml that were ignored. Until you trust a
+project, it can't choose where prompts are sent. For example:
+
+  yoo project
+  yoo project trust`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(projects) == 0 {
+			fmt.Println("no .yoo directory here or above")
+			return
+		}
+		for _, p := range projects {
+			trust := "not trusted"
+			if p.Trusted {
+				trust = "trusted"
+			}
+			fmt.Println(shortPath(p.Root()) + " (" + trust + ")")
+			if len(p.Dropped) > 0 {
+				fmt.Println("  ignored: " + strings.Join(p.Dropped, ", "))
+			}
+		}
+	},
+}
+
+// projectRoot is the root of the project in dir, or of the nearest project
+// when dir is empty.
+func projectRoot(dir string) (string, error) {
+	if dir == "" {
+		if len(projects) == 0 {
+			return "", errors.New("no .yoo directory here or above")
+		}
+		return projects[0].Root(), nil
+	}
+	root, err := filepath.Abs(expandHome(dir))
+	if err != nil {
+		return "", err
+	}
+	if filepath.Base(root) == projectDirName {
+		root = filepath.Dir(root)
+	}
+	if !fileExists(filepath.Join(root, projectDirName)) {
+		return "", fmt.Errorf("%s has no .yoo directory", root)
+	}
+	return root, nil
+}
+
+func init() {
+	rootCmd.AddCommand(projectCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// projectCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// projectCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
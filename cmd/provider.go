@@ -0,0 +1,441 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// Provider is a chat completion backend. The history passed to Complete and
+// Stream does not include the persona's system message; use persona.Messages
+// to build the full conversation.
+// Usage is left zero when the backend doesn't report it.
+type Provider interface {
+	Complete(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage) (string, Usage, error)
+	// Stream calls onDelta with each piece of the reply as it arrives and
+	// returns the assembled reply.
+	Stream(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage, onDelta func(string)) (string, Usage, error)
+}
+
+// ModelLister is implemented by providers that can report which models they
+// serve.
+type ModelLister interface {
+	Models(ctx context.Context) ([]string, error)
+}
+
+// Embedder is implemented by providers that can turn text into vectors, one
+// per text.
+type Embedder interface {
+	Embed(ctx context.Context, model string, texts []string) ([][]float32, Usage, error)
+}
+
+// providers maps the `provider:` value of a persona to a constructor for it.
+var providers = map[string]func(persona Persona) (Provider, error){}
+
+const defaultProvider = "openai"
+
+func registerProvider(name string, newProvider func(persona Persona) (Provider, error)) {
+	providers[name] = newProvider
+}
+
+// providerFor builds the provider selected by the persona.
+func providerFor(persona Persona) (Provider, error) {
+	name := persona.Provider
+	if name == "" {
+		name = defaultProvider
+	}
+	newProvider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q for persona %s", name, persona.Name)
+	}
+	return newProvider(persona)
+}
+
+// personaSetting looks a key up under personas.<name> first and falls back to
+// the global key of the same name.
+func personaSetting(persona Persona, key string) string {
+	if value := viper.GetString("personas." + persona.Name + "." + key); value != "" {
+		return value
+	}
+	return viper.GetString(key)
+}
+
+// personaHeaders merges the global `headers:` map with the persona's own.
+func personaHeaders(persona Persona) map[string]string {
+	headers := map[string]string{}
+	for name, value := range viper.GetStringMapString("headers") {
+		headers[name] = value
+	}
+	for name, value := range viper.GetStringMapString("personas." + persona.Name + ".headers") {
+		headers[name] = value
+	}
+	return headers
+}
+
+// personaEndpoint returns the `endpoint:` of a persona as a base url without a
+// trailing slash, or fallback if it is unset.
+func personaEndpoint(persona Persona, fallback string) string {
+	endpoint := viper.GetString("personas." + persona.Name + ".endpoint")
+	if endpoint == "" {
+		endpoint = fallback
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+// httpStatusError is returned by doJSON for non-2xx responses. Providers fill
+// in Message from their own error body format.
+type httpStatusError struct {
+	URL        string
+	StatusCode int
+	Body       []byte
+	Message    string
+}
+
+func (e *httpStatusError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = strings.TrimSpace(string(e.Body))
+	}
+	if message == "" {
+		message = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s returned %d: %s", e.URL, e.StatusCode, message)
+}
+
+// doJSON sends body (if any) as json and decodes the json response into out.
+func doJSON(ctx context.Context, client *http.Client, method string, url string, body any, out any) error {
+	respBody, err := doStream(ctx, client, method, url, body)
+	if err != nil {
+		return err
+	}
+	defer respBody.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(respBody).Decode(out)
+}
+
+// doStream sends body (if any) as json and returns the response body for the
+// caller to read and close.
+func doStream(ctx context.Context, client *http.Client, method string, url string, body any) (io.ReadCloser, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &httpStatusError{URL: url, StatusCode: resp.StatusCode, Body: respBody}
+	}
+	return resp.Body, nil
+}
+
+type openAIProvider struct {
+	client *openai.Client
+	// streamUsage asks for usage at the end of streamed replies, which
+	// gateways and older azure api versions refuse.
+	streamUsage bool
+}
+
+func newOpenAIProvider(persona Persona) (Provider, error) {
+	key, _, err := resolveSecret(openAIKeySecret, persona)
+	if err != nil {
+		return nil, err
+	}
+	baseURL := personaSetting(persona, "base-url")
+
+	var config openai.ClientConfig
+	switch apiType := personaSetting(persona, "api-type"); apiType {
+	case "", "openai":
+		config = openai.DefaultConfig(key)
+		if baseURL != "" {
+			config.BaseURL = strings.TrimSuffix(baseURL, "/")
+		}
+	case "azure":
+		if baseURL == "" {
+			return nil, fmt.Errorf("persona %s uses api-type azure but has no base-url", persona.Name)
+		}
+		config = openai.DefaultAzureConfig(key, baseURL)
+		if deployment := personaSetting(persona, "deployment"); deployment != "" {
+			config.AzureModelMapperFunc = func(model string) string {
+				return deployment
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown api-type %q for persona %s", apiType, persona.Name)
+	}
+	config.OrgID = personaSetting(persona, "org-id")
+
+	// azure takes the api version from the config, other gateways get it as a
+	// query parameter
+	apiVersion := personaSetting(persona, "api-version")
+	if apiVersion != "" && config.APIType == openai.APITypeAzure {
+		config.APIVersion = apiVersion
+		apiVersion = ""
+	}
+	transport := http.DefaultTransport
+	if headers := personaHeaders(persona); len(headers) > 0 || apiVersion != "" {
+		transport = &gatewayTransport{headers, apiVersion, transport}
+	}
+	config.HTTPClient = &http.Client{Transport: &zeroParamsTransport{transport}}
+
+	// only api.openai.com is known to take stream_options, anything else has
+	// to say so with `stream-usage: true`
+	streamUsage := config.APIType == openai.APITypeOpenAI && (baseURL == "" || strings.Contains(baseURL, "://api.openai.com"))
+	if setting := personaSetting(persona, "stream-usage"); setting != "" {
+		streamUsage = setting == "true"
+	}
+
+	return &openAIProvider{openai.NewClientWithConfig(config), streamUsage}, nil
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage) (string, Usage, error) {
+	resp, err := p.client.CreateChatCompletion(withZeroParams(ctx, persona.Params), chatCompletionRequest(persona, history))
+	if err != nil {
+		return "", Usage{}, err
+	}
+	usage := Usage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}
+	if len(resp.Choices) == 0 {
+		return "", usage, fmt.Errorf("no choices returned for model %s", persona.Model)
+	}
+	return resp.Choices[0].Message.Content, usage, nil
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage, onDelta func(string)) (string, Usage, error) {
+	ctx = withZeroParams(ctx, persona.Params)
+	request := chatCompletionRequest(persona, history)
+	if p.streamUsage {
+		request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+	stream, err := p.client.CreateChatCompletionStream(ctx, request)
+	if request.StreamOptions != nil && isBadRequest(err) {
+		// without usage the tokens are estimated instead
+		request.StreamOptions = nil
+		stream, err = p.client.CreateChatCompletionStream(ctx, request)
+	}
+	if err != nil {
+		return "", Usage{}, err
+	}
+	defer stream.Close()
+
+	var reply strings.Builder
+	usage := Usage{}
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return reply.String(), usage, nil
+		}
+		if err != nil {
+			return reply.String(), usage, err
+		}
+		if resp.Usage != nil {
+			usage = Usage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}
+		}
+		for _, choice := range resp.Choices {
+			if choice.Delta.Content != "" {
+				reply.WriteString(choice.Delta.Content)
+				onDelta(choice.Delta.Content)
+			}
+		}
+	}
+}
+
+// chatCompletionRequest builds the request for a persona, with its model
+// params.
+func chatCompletionRequest(persona Persona, history []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+	params := persona.Params
+	return openai.ChatCompletionRequest{
+		Model:            persona.Model,
+		Messages:         persona.Messages(history),
+		Temperature:      paramValue(params.Temperature),
+		TopP:             paramValue(params.TopP),
+		MaxTokens:        params.MaxTokens,
+		Stop:             params.Stop,
+		PresencePenalty:  paramValue(params.PresencePenalty),
+		FrequencyPenalty: paramValue(params.FrequencyPenalty),
+		Seed:             params.Seed,
+	}
+}
+
+// isBadRequest reports whether the api refused a request as malformed.
+func isBadRequest(err error) bool {
+	var apiErr *openai.APIError
+	var requestErr *openai.RequestError
+	return errors.As(err, &apiErr) && apiErr.HTTPStatusCode == http.StatusBadRequest ||
+		errors.As(err, &requestErr) && requestErr.HTTPStatusCode == http.StatusBadRequest
+}
+
+// paramValue unwraps a param for the openai client. Params set to 0 are left
+// out by the client, and put back by zeroParamsTransport.
+func paramValue(param *float32) float32 {
+	if param == nil {
+		return 0
+	}
+	return *param
+}
+
+// zeroParamsKey is the context key for the json names of the params a
+// request sets to 0.
+type zeroParamsKey struct{}
+
+// withZeroParams notes on ctx which of params are set to 0.
+func withZeroParams(ctx context.Context, params ModelParams) context.Context {
+	zeros := []string{}
+	for name, param := range map[string]*float32{
+		"temperature":       params.Temperature,
+		"top_p":             params.TopP,
+		"presence_penalty":  params.PresencePenalty,
+		"frequency_penalty": params.FrequencyPenalty,
+	} {
+		if param != nil && *param == 0 {
+			zeros = append(zeros, name)
+		}
+	}
+	if len(zeros) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, zeroParamsKey{}, zeros)
+}
+
+// zeroParamsTransport writes the params noted by withZeroParams into the
+// request body as 0. The openai client tags its float params omitempty, so a
+// 0 never reaches the api, which then uses its own default instead (a
+// temperature of 1), and a persona asking for deterministic replies doesn't
+// get them. Only chat completion requests are touched.
+type zeroParamsTransport struct {
+	base http.RoundTripper
+}
+
+func (t *zeroParamsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	zeros, _ := req.Context().Value(zeroParamsKey{}).([]string)
+	if len(zeros) == 0 || req.Body == nil || req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return t.base.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err == nil {
+		for _, name := range zeros {
+			fields[name] = json.RawMessage("0")
+		}
+		if encoded, err := json.Marshal(fields); err == nil {
+			body = encoded
+		}
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Models lists the models available to the api key.
+func (p *openAIProvider) Models(ctx context.Context) ([]string, error) {
+	resp, err := p.client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	models := []string{}
+	for _, model := range resp.Models {
+		models = append(models, model.ID)
+	}
+	return models, nil
+}
+
+// Embed embeds texts with one of the embedding models.
+func (p *openAIProvider) Embed(ctx context.Context, model string, texts []string) ([][]float32, Usage, error) {
+	resp, err := p.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: texts,
+		Model: openai.EmbeddingModel(model),
+	})
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, Usage{}, fmt.Errorf("asked for %d embeddings but got %d", len(texts), len(resp.Data))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, embedding := range resp.Data {
+		if embedding.Index < 0 || embedding.Index >= len(texts) {
+			return nil, Usage{}, fmt.Errorf("embedding %d is out of range", embedding.Index)
+		}
+		vectors[embedding.Index] = embedding.Embedding
+	}
+	return vectors, Usage{PromptTokens: resp.Usage.PromptTokens}, nil
+}
+
+// gatewayTransport adds the extra headers and api version that proxies and
+// openai-compatible gateways ask for.
+type gatewayTransport struct {
+	headers    map[string]string
+	apiVersion string
+	base       http.RoundTripper
+}
+
+func (t *gatewayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	if t.apiVersion != "" {
+		query := req.URL.Query()
+		query.Set("api-version", t.apiVersion)
+		req.URL.RawQuery = query.Encode()
+	}
+	return t.base.RoundTrip(req)
+}
+
+func init() {
+	registerProvider("openai", newOpenAIProvider)
+}
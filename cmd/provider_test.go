@@ -0,0 +1,171 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// fakeProvider answers every request with reply, or fails with err, keeping
+// the histories it was sent.
+type fakeProvider struct {
+	reply    string
+	err      error
+	requests [][]openai.ChatCompletionMessage
+}
+
+func (p *fakeProvider) Complete(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage) (string, Usage, error) {
+	p.requests = append(p.requests, history)
+	if p.err != nil {
+		return "", Usage{}, p.err
+	}
+	return p.reply, Usage{}, nil
+}
+
+func (p *fakeProvider) Stream(ctx context.Context, persona Persona, history []openai.ChatCompletionMessage, onDelta func(string)) (string, Usage, error) {
+	reply, usage, err := p.Complete(ctx, persona, history)
+	if err == nil {
+		onDelta(reply)
+	}
+	return reply, usage, err
+}
+
+// testConfig starts the test from an empty config with settings set, with
+// logs, usage and personas kept in a temporary directory.
+func testConfig(t *testing.T, settings map[string]any) string {
+	t.Helper()
+	dir := t.TempDir() + "/"
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("logpath", dir)
+	viper.Set("configpath", dir)
+	viper.Set("quiet", true)
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+	projects = nil
+	return dir
+}
+
+func TestProviderFor(t *testing.T) {
+	testConfig(t, nil)
+	registerProvider("fake", func(persona Persona) (Provider, error) {
+		return &fakeProvider{reply: persona.Name}, nil
+	})
+	t.Cleanup(func() { delete(providers, "fake") })
+
+	if provider, err := providerFor(Persona{Name: "default"}); err != nil {
+		t.Errorf("no provider for the default: %v", err)
+	} else if _, ok := provider.(*openAIProvider); !ok {
+		t.Errorf("default provider is %T, want openai", provider)
+	}
+
+	provider, err := providerFor(Persona{Name: "archie", Provider: "fake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, _, err := provider.Complete(context.Background(), Persona{Name: "archie"}, nil)
+	if err != nil || reply != "archie" {
+		t.Errorf("fake provider replied %q (%v), want archie", reply, err)
+	}
+
+	if _, err := providerFor(Persona{Name: "archie", Provider: "nope"}); err == nil {
+		t.Error("found a provider that isn't registered")
+	}
+}
+
+func TestZeroParams(t *testing.T) {
+	zero, half := float32(0), float32(0.5)
+	tests := []struct {
+		name   string
+		params ModelParams
+		want   map[string]any
+	}{
+		{"unset", ModelParams{}, map[string]any{}},
+		{"zero", ModelParams{Temperature: &zero, FrequencyPenalty: &zero}, map[string]any{"temperature": 0.0, "frequency_penalty": 0.0}},
+		{"zero and set", ModelParams{Temperature: &zero, TopP: &half}, map[string]any{"temperature": 0.0, "top_p": 0.5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body map[string]any
+			var raw []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				raw, _ = io.ReadAll(r.Body)
+				json.Unmarshal(raw, &body)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "ok"}}]}`))
+			}))
+			defer server.Close()
+			testConfig(t, map[string]any{"openai-key": "sk-test", "base-url": server.URL})
+
+			persona := Persona{Name: "zero", Model: "gpt-4", Params: test.params}
+			provider, err := providerFor(persona)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := provider.Complete(context.Background(), persona, nil); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"temperature", "top_p", "presence_penalty", "frequency_penalty"} {
+				value, ok := body[name]
+				want, wantOK := test.want[name]
+				if ok != wantOK || value != want {
+					t.Errorf("%s is %v (sent %t), want %v (sent %t)", name, value, ok, want, wantOK)
+				}
+				if want == 0.0 && !strings.Contains(string(raw), `"`+name+`":0`) {
+					t.Errorf("%s isn't sent as 0 in %s", name, raw)
+				}
+			}
+		})
+	}
+}
+
+func TestZeroParamsOnlyInChatCompletions(t *testing.T) {
+	zero := float32(0)
+	var raw []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	ctx := withZeroParams(context.Background(), ModelParams{Temperature: &zero})
+	client := &http.Client{Transport: &zeroParamsTransport{http.DefaultTransport}}
+	for _, path := range []string{"/v1/embeddings", "/v1/completions"} {
+		body := `{"model":"text-embedding-3-small","input":["hi"]}`
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if string(raw) != body {
+			t.Errorf("%s body was changed to %s", path, raw)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// rebuildCmd represents the rebuild command
+var rebuildCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
+	Use:   "rebuild",
+	Short: "Rebuild the search index from the logs",
+	Long: `Throw the search index away and index every log again, e.g. after editing logs
+by hand. For example:
+
+  yoo index rebuild`,
+	Run: func(cmd *cobra.Command, args []string) {
+		start := time.Now()
+		unlock, err := lockSearchIndex(viper.GetString("logpath"))
+		checkError(err, "could not rebuild search index", true)
+		index := newSearchIndex(viper.GetString("logpath"))
+		count, err := index.refresh()
+		if err == nil && count == 0 {
+			// refresh only saves when something changed
+			err = index.save()
+		}
+		// let go before checkError, which exits without running defers
+		unlock()
+		checkError(err, "could not rebuild search index", true)
+		fmt.Printf("indexed %d logs in %s\n", count, time.Since(start).Round(time.Millisecond))
+	},
+}
+
+func init() {
+	indexCmd.AddCommand(rebuildCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// rebuildCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// rebuildCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
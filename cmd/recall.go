@@ -0,0 +1,96 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	termutil "github.com/andrew-d/go-termutil"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// recallCmd represents the recall command
+var recallCmd = &cobra.Command{
+	Args:  cobra.MinimumNArgs(1),
+	Use:   "recall <question>",
+	Short: "Find past conversations by meaning",
+	Long: `Find the past exchanges closest in meaning to a question, even when they use
+other words. Every prompt and reply is embedded with the embeddings api of the
+persona's provider (or of embedding-persona) and kept next to the logs; new
+logs are embedded the next time you recall. Exchanges with every persona are
+searched, --embedding-persona only picks whose embedding settings are used.
+For example:
+
+  yoo recall "that time I cleaned up the pacman cache"
+  yoo recall -n 3 --embedding-persona archie mirrors`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := recallEmbeddingPersona
+		if name == "" {
+			name = viper.GetString("persona")
+		}
+		persona, err := loadPersona(name)
+		checkError(err, "could not load persona "+name, true)
+
+		matches, err := recall(context.Background(), persona, strings.Join(args, " "), recallCount, "", func(count int) {
+			if !viper.GetBool("quiet") {
+				fmt.Fprintf(os.Stderr, "embedding %d logs...\n", count)
+			}
+		})
+		checkError(err, "could not recall past conversations", true)
+		if len(matches) == 0 {
+			fmt.Println("nothing to recall yet")
+			os.Exit(1)
+		}
+
+		highlight := highlighter(termutil.Isatty(os.Stdout.Fd()))
+		for i, match := range matches {
+			if i > 0 {
+				fmt.Println()
+			}
+			entry := logEntry{Path: filepath.Join(viper.GetString("logpath"), match.Name), Meta: match.Log.Meta, ModTime: match.Log.ModTime}
+			fmt.Println(highlight(fmt.Sprintf("%.2f  %s  %s  %s", match.Score, entry.Date().Format("2006-01-02"), entry.Meta.Persona, entry.Title()), "1;34"))
+			fmt.Println(highlight(shortPath(entry.Path), "2"))
+			fmt.Println("  ≫ " + oneLine(match.Prompt, 150))
+			fmt.Println("  " + oneLine(match.Response, 150))
+		}
+	},
+}
+
+var (
+	recallEmbeddingPersona string
+	recallCount            int
+)
+
+func init() {
+	rootCmd.AddCommand(recallCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// recallCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	recallCmd.Flags().IntVarP(&recallCount, "limit", "n", 5, "the number of exchanges to show")
+	recallCmd.Flags().StringVar(&recallEmbeddingPersona, "embedding-persona", "", "the persona whose embedding settings to use, rather than the chat persona's")
+}
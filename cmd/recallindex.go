@@ -0,0 +1,276 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// recallIndexVersion is bumped whenever what's embedded changes.
+const recallIndexVersion = 1
+
+// recallIndex holds a vector for every exchange in the logs, kept in the log
+// directory as .recall-index-<model hash>. Like the search index it's only a
+// cache, and each embedding model has its own.
+type recallIndex struct {
+	Version int
+	// Model is the embedding the vectors come from, as embedding.ID.
+	Model string
+	// Logs are the embedded logs by file name.
+	Logs map[string]recalledLog
+
+	dir string
+}
+
+type recalledLog struct {
+	Meta      LogMeta
+	ModTime   time.Time
+	Size      int64
+	Exchanges []recalledExchange
+}
+
+// recalledExchange is a prompt and its reply.
+type recalledExchange struct {
+	// Message is the position of the prompt in the log's history, the reply
+	// follows it.
+	Message int
+	Vector  []float32
+}
+
+// recallMatch is an exchange similar to a question.
+type recallMatch struct {
+	Name     string
+	Log      recalledLog
+	Message  int
+	Score    float32
+	Prompt   string
+	Response string
+}
+
+func recallIndexPath(dir string, model string) string {
+	return filepath.Join(dir, indexName(".recall-index", model))
+}
+
+// loadRecallIndex reads the vectors model made of the logs in dir, starting
+// over when they're missing or unreadable.
+func loadRecallIndex(dir string, model string) *recallIndex {
+	empty := &recallIndex{Version: recallIndexVersion, Model: model, Logs: map[string]recalledLog{}, dir: dir}
+	file, err := os.Open(recallIndexPath(dir, model))
+	if err != nil {
+		return empty
+	}
+	defer file.Close()
+	var index recallIndex
+	if err := gob.NewDecoder(file).Decode(&index); err != nil || index.Version != recallIndexVersion || index.Model != model {
+		return empty
+	}
+	index.dir = dir
+	return &index
+}
+
+// save writes the index atomically.
+func (ix *recallIndex) save() error {
+	tmp, err := os.CreateTemp(ix.dir, ".recall-index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(ix); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), recallIndexPath(ix.dir, ix.Model))
+}
+
+// refresh embeds the logs that are new or changed, apart from the one named
+// skip, and drops the ones that are gone. progress is told how many logs are
+// about to be embedded. What was embedded is saved even if a later request
+// fails.
+func (ix *recallIndex) refresh(ctx context.Context, e embedding, skip string, progress func(int)) error {
+	dirEntries, err := os.ReadDir(ix.dir)
+	if err != nil {
+		return err
+	}
+	changed := false
+	pending := []os.FileInfo{}
+	seen := map[string]bool{}
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil || !isLogFile(info) || info.Name() == skip {
+			continue
+		}
+		seen[info.Name()] = true
+		recalled, ok := ix.Logs[info.Name()]
+		if !ok || !recalled.ModTime.Equal(info.ModTime()) || recalled.Size != info.Size() {
+			pending = append(pending, info)
+		}
+	}
+	for name := range ix.Logs {
+		if !seen[name] {
+			delete(ix.Logs, name)
+			changed = true
+		}
+	}
+	if len(pending) > 0 && progress != nil {
+		progress(len(pending))
+	}
+
+	for _, info := range pending {
+		recalled := recalledLog{ModTime: info.ModTime(), Size: info.Size()}
+		// logs that can't be read are remembered as empty until they change
+		if l, err := readChatLog(filepath.Join(ix.dir, info.Name())); err == nil {
+			recalled.Meta = l.Meta
+			texts := []string{}
+			for i := 0; i+1 < len(l.History); i++ {
+				if l.History[i].Role != openai.ChatMessageRoleUser || l.History[i+1].Role != openai.ChatMessageRoleAssistant {
+					continue
+				}
+				recalled.Exchanges = append(recalled.Exchanges, recalledExchange{Message: i})
+				texts = append(texts, exchangeText(l.Meta.Title, l.History[i].Content, l.History[i+1].Content))
+			}
+			vectors, err := e.embed(ctx, texts)
+			if err != nil {
+				if changed {
+					ix.save()
+				}
+				return err
+			}
+			for i := range recalled.Exchanges {
+				recalled.Exchanges[i].Vector = vectors[i]
+			}
+		}
+		ix.Logs[info.Name()] = recalled
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return ix.save()
+}
+
+// exchangeText is what's embedded for an exchange.
+func exchangeText(title string, prompt string, response string) string {
+	return clipForEmbedding(title + "\n\n" + prompt + "\n\n" + response)
+}
+
+// nearest returns the k exchanges most similar to vector, leaving out the log
+// named exclude, with their prompts and replies read back from the logs.
+func (ix *recallIndex) nearest(vector []float32, k int, exclude string) []recallMatch {
+	matches := []recallMatch{}
+	for name, recalled := range ix.Logs {
+		if name == exclude {
+			continue
+		}
+		for _, exchange := range recalled.Exchanges {
+			matches = append(matches, recallMatch{
+				Name:    name,
+				Log:     recalled,
+				Message: exchange.Message,
+				Score:   similarity(vector, exchange.Vector),
+			})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	found := []recallMatch{}
+	for _, match := range matches {
+		if len(found) == k {
+			break
+		}
+		l, err := readChatLog(filepath.Join(ix.dir, match.Name))
+		if err != nil || match.Message+1 >= len(l.History) {
+			continue
+		}
+		match.Prompt = l.History[match.Message].Content
+		match.Response = l.History[match.Message+1].Content
+		found = append(found, match)
+	}
+	return found
+}
+
+// recall finds the k past exchanges closest to question, leaving out the log
+// named exclude, and embedding any logs that are new first.
+func recall(ctx context.Context, persona Persona, question string, k int, exclude string, progress func(int)) ([]recallMatch, error) {
+	e, err := embeddingFor(persona)
+	if err != nil {
+		return nil, err
+	}
+	index := loadRecallIndex(viper.GetString("logpath"), e.ID())
+	if err := index.refresh(ctx, e, exclude, progress); err != nil {
+		return nil, err
+	}
+	vectors, err := e.embed(ctx, []string{clipForEmbedding(question)})
+	if err != nil {
+		return nil, err
+	}
+	return index.nearest(vectors[0], k, exclude), nil
+}
+
+// withRecall adds the persona's `recall: k` most relevant past answers to
+// its system message, leaving out the conversation in currentLog. Progress
+// and problems are passed to notice, and on failure the persona is used as
+// it is.
+func withRecall(ctx context.Context, persona Persona, prompt string, currentLog string, notice func(string)) Persona {
+	k, err := strconv.Atoi(personaSetting(persona, "recall"))
+	if err != nil || k <= 0 {
+		return persona
+	}
+	matches, err := recall(ctx, persona, prompt, k, filepath.Base(currentLog), func(count int) {
+		notice(fmt.Sprintf("embedding %d logs to recall from", count))
+	})
+	if err != nil {
+		notice("could not recall past conversations: " + err.Error())
+		return persona
+	}
+	if len(matches) == 0 {
+		return persona
+	}
+
+	var context strings.Builder
+	context.WriteString("\n\nThese exchanges from earlier conversations may be relevant:")
+	for _, match := range matches {
+		fmt.Fprintf(&context, "\n\n### %s (%s)\n\nuser: %s\n\nassistant: %s",
+			match.Log.Meta.Title, match.Log.Meta.Started.Format("2006-01-02"), match.Prompt, match.Response)
+	}
+	persona.SystemMessage.Content = strings.TrimRight(persona.SystemMessage.Content, "\n") + context.String()
+	return persona
+}
+
+// oneLine squashes text onto a single line of at most width characters.
+func oneLine(text string, width int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) <= width {
+		return string(runes)
+	}
+	return string(runes[:width-1]) + "…"
+}
@@ -0,0 +1,52 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// resumeCmd represents the resume command
+var resumeCmd = &cobra.Command{
+	Args:  cobra.ExactArgs(1),
+	Use:   "resume <file|title-substring>",
+	Short: "Resume an earlier conversation",
+	Long: `Resume a conversation from a log file, given either its path or part of its
+name. The most recent log matching the name is used. For example:
+
+  yoo chat resume pacman-cache
+  yoo chat resume ~/.yoo/2023-04-01--10-00-00-PDT.pacman-cache.md`,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := findLog(args[0])
+		checkError(err, "could not find log", true)
+		resumeChat(path)
+	},
+}
+
+func init() {
+	chatCmd.AddCommand(resumeCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// resumeCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// resumeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
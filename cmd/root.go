@@ -18,13 +18,19 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/briandowns/spinner"
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 var cfgFile string
@@ -63,6 +69,11 @@ func init() {
 	viper.BindPFlag("quiet", rootCmd.PersistentFlags().Lookup("quiet"))
 	rootCmd.PersistentFlags().String("title", "", "title for the log file that is used instead of a separate LLM request")
 	viper.BindPFlag("title", rootCmd.PersistentFlags().Lookup("title"))
+	rootCmd.PersistentFlags().StringSlice("tag", []string{}, "tag to record in the log file (repeatable)")
+	viper.BindPFlag("tags", rootCmd.PersistentFlags().Lookup("tag"))
+	rootCmd.PersistentFlags().Bool("force", false, "send requests even if they go over a budget, and large attachments without asking")
+	viper.BindPFlag("force", rootCmd.PersistentFlags().Lookup("force"))
+	rootCmd.PersistentFlags().StringArrayVar(&templateVars, "var", []string{}, "key=value variable for templated system prompts (repeatable)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -89,36 +100,416 @@ func initConfig() {
 
 	viper.AutomaticEnv() // read in environment variables that match
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err != nil {
-		fmt.Fprintln(os.Stderr, "could not load config file")
+	// If a config file is found, read it in, along with any project config.
+	if err := readConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "could not load config file, run `yoo doctor` to find out why")
 	}
 }
 
+// personaFile is the front matter of a `<name>.md` persona file. Any of it can
+// also be set under personas.<name> in the config, which the file overrides.
+type personaFile struct {
+	Model    string `yaml:"model,omitempty"`
+	Provider string `yaml:"provider,omitempty"`
+	// Extends names a persona to build on, and Include lists files, relative
+	// to the persona file, to add to the system prompt.
+	Extends     string   `yaml:"extends,omitempty"`
+	Include     []string `yaml:"include,omitempty"`
+	ModelParams `yaml:",inline"`
+	// Examples are user and assistant pairs to show the model first.
+	Examples []personaExample `yaml:"examples,omitempty"`
+	// Knowledge lists directories of documents, relative to the persona file,
+	// to answer from.
+	Knowledge []string `yaml:"knowledge,omitempty"`
+}
+
+type personaExample struct {
+	User      string `yaml:"user"`
+	Assistant string `yaml:"assistant"`
+}
+
+// loadSystemPrompt reads a persona's system prompt from `<name>.md`, without
+// its front matter, or else from `<name>.txt`. It also returns the path read.
 func loadSystemPrompt(persona string) (string, string, error) {
-	systemfile := viper.GetString("configpath") + persona + ".txt"
-	systemcontent, err := os.ReadFile(systemfile)
-	return string(systemcontent), systemfile, err
+	_, systemcontent, systemfile, err := readPersonaFile(persona)
+	return systemcontent, systemfile, err
 }
 
-func createChatCompletion(client *openai.Client, persona Persona, prompt string, historySlice []openai.ChatCompletionMessage) (string, error) {
-	systemSlice := []openai.ChatCompletionMessage{persona.SystemMessage}
+// readPersonaFile reads a persona's file, returning its raw front matter,
+// system prompt and path. `.txt` files are all system prompt. Project
+// personas are found before the user's.
+func readPersonaFile(persona string) (string, string, string, error) {
+	var systemfile string
+	var content []byte
+	err := fs.ErrNotExist
+	for _, dir := range personaDirs() {
+		for _, ext := range []string{".md", ".txt"} {
+			systemfile = dir + persona + ext
+			if content, err = os.ReadFile(systemfile); !errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+	if err != nil {
+		return "", "", systemfile, err
+	}
+	if strings.HasSuffix(systemfile, ".txt") {
+		return "", string(content), systemfile, nil
+	}
+
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	rest, ok := strings.CutPrefix(text, "---\n")
+	if !ok {
+		return "", text, systemfile, nil
+	}
+	// the closing --- can come straight after the opening one
+	frontMatter, body, ok := strings.Cut("\n"+rest, "\n---\n")
+	if !ok {
+		return "", "", systemfile, fmt.Errorf("%s: front matter is not closed", systemfile)
+	}
+	return strings.TrimPrefix(frontMatter, "\n"), strings.TrimPrefix(body, "\n"), systemfile, nil
+}
+
+// loadPersona builds a persona from its config entry and persona file.
+func loadPersona(name string) (Persona, error) {
+	resolved, err := resolvePersona(name, nil)
+	if err != nil {
+		return Persona{}, err
+	}
+	persona := Persona{
+		Name:      name,
+		Model:     resolved.Model,
+		Provider:  resolved.Provider,
+		Params:    resolved.ModelParams,
+		Examples:  []openai.ChatCompletionMessage{},
+		Knowledge: resolved.Knowledge,
+	}
+	for _, example := range resolved.Examples {
+		persona.Examples = append(persona.Examples,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: example.User},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: example.Assistant})
+	}
+	persona.SystemMessage = openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: renderSystemPrompt(persona, resolved),
+	}
+	return persona, nil
+}
+
+// resolvedPersona is a persona with its parents and includes folded in.
+type resolvedPersona struct {
+	personaFile
+	System string
+	// Sources are the files the system prompt was put together from, in
+	// order.
+	Sources []string
+	// ChosenBy are the projects that picked any of the Sources, in one of
+	// their persona files or their config.yml.
+	ChosenBy []project
+}
+
+// resolvePersona reads a persona, building on the persona it extends and
+// adding the fragments it includes to its system prompt: the parent's
+// prompt comes first, then the includes, then the persona's own. The
+// model, provider, params and examples are inherited unless the persona sets
+// its own.
+// seen holds the personas being resolved, to catch cycles.
+func resolvePersona(name string, seen []string) (resolvedPersona, error) {
+	for _, parent := range seen {
+		if parent == name {
+			return resolvedPersona{}, fmt.Errorf("personas extend each other in a cycle: %s", strings.Join(append(seen, name), " → "))
+		}
+	}
+	seen = append(seen, name)
+
+	// the config entry is read the same way as front matter, so the file only
+	// overrides what it sets
+	var config []byte
+	if settings := viper.GetStringMap("personas." + name); len(settings) > 0 {
+		encoded, err := yaml.Marshal(settings)
+		if err != nil {
+			return resolvedPersona{}, fmt.Errorf("personas.%s in config: %w", name, err)
+		}
+		config = encoded
+	}
+	decode := func(meta *personaFile, frontMatter string, systemfile string) error {
+		if err := yaml.Unmarshal(config, meta); err != nil {
+			return fmt.Errorf("personas.%s in config: %w", name, err)
+		}
+		if err := yaml.Unmarshal([]byte(frontMatter), meta); err != nil {
+			return fmt.Errorf("%s: %w", systemfile, err)
+		}
+		return nil
+	}
+
+	frontMatter, systemPrompt, systemfile, err := readPersonaFile(name)
+	missing := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !missing {
+		return resolvedPersona{}, err
+	}
+	frontMatter, trustErr := projectFrontMatter(systemfile, frontMatter)
+	if trustErr != nil {
+		return resolvedPersona{}, trustErr
+	}
+	own := personaFile{}
+	if err := decode(&own, frontMatter, systemfile); err != nil {
+		return resolvedPersona{}, err
+	}
+	// a persona that extends another doesn't need a prompt of its own
+	if missing && own.Extends == "" {
+		return resolvedPersona{}, err
+	}
+
+	resolved := resolvedPersona{personaFile: own}
+	parts := []string{}
+	if own.Extends != "" {
+		parent, err := resolvePersona(own.Extends, seen)
+		if errors.Is(err, fs.ErrNotExist) {
+			return resolvedPersona{}, fmt.Errorf("persona %s extends %s, which doesn't exist", name, own.Extends)
+		}
+		if err != nil {
+			return resolvedPersona{}, err
+		}
+		resolved = parent
+		if err := decode(&resolved.personaFile, frontMatter, systemfile); err != nil {
+			return resolvedPersona{}, err
+		}
+		resolved.Extends, resolved.Include = own.Extends, own.Include
+		if parent.System != "" {
+			parts = append(parts, strings.TrimSpace(parent.System))
+		}
+	}
+
+	// paths set by a project, in one of its persona files or in its
+	// config.yml, are kept inside it. config.yml paths are relative to the
+	// project root
+	dir := filepath.Dir(systemfile)
+	fromFile := personaFile{}
+	yaml.Unmarshal([]byte(frontMatter), &fromFile)
+	sourceProject := func(key string, inFile bool) (project, string, bool) {
+		if inFile {
+			p, ok := projectOf(systemfile)
+			return p, dir, ok
+		}
+		if p, ok := personaSettingProject(name, key); ok {
+			return p, p.Root(), true
+		}
+		return project{}, dir, false
+	}
+
+	p, base, inProject := sourceProject("include", len(fromFile.Include) > 0)
+	if inProject && len(own.Include) > 0 {
+		resolved.ChosenBy = append(resolved.ChosenBy, p)
+	}
+	for _, include := range own.Include {
+		path := personaPath(dir, include)
+		if inProject {
+			if path, err = projectPath(p, base, include); err != nil {
+				return resolvedPersona{}, fmt.Errorf("persona %s includes %s: %w", name, include, err)
+			}
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return resolvedPersona{}, fmt.Errorf("persona %s includes %s: %w", name, include, err)
+		}
+		parts = append(parts, strings.TrimSpace(string(content)))
+		resolved.Sources = append(resolved.Sources, path)
+	}
+
+	examples, err := readExamplesDir(filepath.Join(dir, "examples", name))
+	if err != nil {
+		return resolvedPersona{}, fmt.Errorf("examples for persona %s: %w", name, err)
+	}
+	if examples = append(own.Examples, examples...); len(examples) > 0 {
+		resolved.Examples = examples
+	}
+
+	// a parent's knowledge is already resolved against its own file. a
+	// project's personas know the project's docs, relative to its root
+	if len(own.Knowledge) > 0 {
+		resolved.Knowledge = []string{}
+		p, _, inProject := sourceProject("knowledge", len(fromFile.Knowledge) > 0)
+		for _, knowledge := range own.Knowledge {
+			path := personaPath(dir, knowledge)
+			if inProject {
+				if path, err = projectPath(p, p.Root(), knowledge); err != nil {
+					return resolvedPersona{}, fmt.Errorf("persona %s knows %s: %w", name, knowledge, err)
+				}
+			}
+			resolved.Knowledge = append(resolved.Knowledge, path)
+		}
+	}
+
+	if !missing {
+		parts = append(parts, systemPrompt)
+		resolved.Sources = append(resolved.Sources, systemfile)
+	}
+	resolved.System = strings.Join(parts, "\n\n")
+	return resolved, nil
+}
+
+// personaPath resolves a path given in a persona file in dir: relative to
+// dir, or to the home directory for ~/.
+func personaPath(dir string, path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return expandHome(path)
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(dir, path)
+	}
+	return path
+}
+
+// readExamplesDir reads examples kept as files in dir, a pair of
+// <name>.user.md and <name>.assistant.md for each example, in name order.
+func readExamplesDir(dir string) ([]personaExample, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	examples := []personaExample{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".user.md")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		user, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		assistant, err := os.ReadFile(filepath.Join(dir, name+".assistant.md"))
+		if err != nil {
+			return nil, err
+		}
+		examples = append(examples, personaExample{
+			User:      strings.TrimRight(string(user), "\n"),
+			Assistant: strings.TrimRight(string(assistant), "\n"),
+		})
+	}
+	return examples, nil
+}
+
+// loadResources sets up the chat and title personas along with their providers.
+func loadResources() LoadedResources {
+	chatPersona, err := loadPersona(viper.GetString("persona"))
+	checkError(err, "could not load persona", true)
+	chatProvider, err := providerFor(chatPersona)
+	checkError(err, "could not set up provider for "+chatPersona.Name, true)
+
+	titlePersona, err := loadPersona(viper.GetString("title-persona"))
+	checkError(err, "could not load title persona", true)
+	titleProvider, err := providerFor(titlePersona)
+	checkError(err, "could not set up provider for "+titlePersona.Name, true)
+
+	return LoadedResources{
+		ChatPersona:   chatPersona,
+		ChatProvider:  chatProvider,
+		TitlePersona:  titlePersona,
+		TitleProvider: titleProvider,
+		LogDir:        viper.GetString("logpath"),
+	}
+}
+
+func createChatCompletion(ctx context.Context, provider Provider, persona Persona, prompt string, historySlice []openai.ChatCompletionMessage) (string, Usage, error) {
 	userMessage := openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
 		Content: prompt,
 	}
-	fullHistorySlice := append(systemSlice, historySlice...)
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:    persona.Model,
-			Messages: append(fullHistorySlice, userMessage),
-		},
-	)
+	history := append(historySlice[:len(historySlice):len(historySlice)], userMessage)
+	err := checkBudget(persona, history, func(warning string) {
+		fmt.Fprintln(os.Stderr, "warning: "+warning)
+	})
+	if err != nil {
+		return "", Usage{}, err
+	}
+	reply, usage, err := provider.Complete(ctx, persona, history)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	return reply, recordUsage(persona, history, reply, usage), nil
+}
+
+// streamChatCompletion is createChatCompletion for replies shown to the user:
+// the reply is printed as it arrives, after stopping the spinner and printing
+// prefix on the first token. The assembled reply is returned, even when it
+// was cut short by an error.
+func streamChatCompletion(ctx context.Context, provider Provider, persona Persona, prompt string, historySlice []openai.ChatCompletionMessage, s *spinner.Spinner, prefix string) (string, Usage, error) {
+	userMessage := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: prompt,
+	}
+	history := append(historySlice[:len(historySlice):len(historySlice)], userMessage)
+	err := checkBudget(persona, history, func(warning string) {
+		restart := s.Active()
+		if restart {
+			s.Stop()
+		}
+		fmt.Fprintln(os.Stderr, "warning: "+warning)
+		if restart {
+			s.Start()
+		}
+	})
 	if err != nil {
-		return "", err
+		if s.Active() {
+			s.Stop()
+		}
+		return "", Usage{}, err
+	}
+
+	started := false
+	reply, usage, err := provider.Stream(ctx, persona, history, func(delta string) {
+		if !started {
+			started = true
+			if s.Active() {
+				s.Stop()
+			}
+			fmt.Print(prefix)
+		}
+		fmt.Print(delta)
+	})
+	if started {
+		fmt.Println()
+	} else if s.Active() {
+		s.Stop()
+	}
+	if reply != "" {
+		usage = recordUsage(persona, history, reply, usage)
+	}
+	return reply, usage, err
+}
+
+// generateTitle asks the title persona for a slug to name the log after,
+// unless one was given with --title.
+func generateTitle(resources LoadedResources, userPrompt string) string {
+	title := viper.GetString("title")
+	if title == "" {
+		titleContent := div("system") + resources.ChatPersona.SystemMessage.Content + div("prompt") + userPrompt
+		generated, _, err := createChatCompletion(
+			context.Background(),
+			resources.TitleProvider,
+			resources.TitlePersona,
+			titleContent,
+			[]openai.ChatCompletionMessage{})
+		checkError(err, "could not complete request for title slug", false)
+		title = generated
+	}
+
+	return slugTitle(title)
+}
+
+// slugTitle makes a title safe to use in a file name.
+func slugTitle(title string) string {
+	title = strings.Join(strings.Fields(strings.ReplaceAll(title, "/", "-")), "-")
+	if title == "" {
+		title = "unknown-topic"
 	}
-	return resp.Choices[0].Message.Content, nil
+	return title
 }
 
 func div(title string) string {
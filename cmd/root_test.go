@@ -0,0 +1,54 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReadPersonaFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		frontMatter string
+		system      string
+		err         bool
+	}{
+		{name: "no front matter", content: "you are archie\n", system: "you are archie\n"},
+		{name: "front matter", content: "---\nmodel: gpt-4\n---\n\nyou are archie\n", frontMatter: "model: gpt-4", system: "you are archie\n"},
+		{name: "empty front matter", content: "---\n---\nyou are archie\n", system: "you are archie\n"},
+		{name: "crlf", content: "---\r\nmodel: gpt-4\r\ntemperature: 0\r\n---\r\n\r\nyou are archie\r\n", frontMatter: "model: gpt-4\ntemperature: 0", system: "you are archie\n"},
+		{name: "rule in the prompt", content: "---\nmodel: gpt-4\n---\nbefore\n---\nafter\n", frontMatter: "model: gpt-4", system: "before\n---\nafter\n"},
+		{name: "not closed", content: "---\nmodel: gpt-4\nyou are archie\n", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := testConfig(t, nil)
+			if err := os.WriteFile(dir+"archie.md", []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			frontMatter, system, _, err := readPersonaFile("archie")
+			if (err != nil) != test.err {
+				t.Fatalf("error is %v, want one: %t", err, test.err)
+			}
+			if frontMatter != test.frontMatter || system != test.system {
+				t.Errorf("read %q and %q, want %q and %q", frontMatter, system, test.frontMatter, test.system)
+			}
+		})
+	}
+}
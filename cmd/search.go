@@ -0,0 +1,104 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	termutil "github.com/andrew-d/go-termutil"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// searchCmd represents the search command
+var searchCmd = &cobra.Command{
+	Args:  cobra.MinimumNArgs(1),
+	Use:   "search <query>",
+	Short: "Search logs by keyword",
+	Long: `Search every log for the words of a query, best matches first, with a snippet
+of each. Logs are kept in an index next to them that's updated when a chat
+ends and whenever you search; run yoo index rebuild if it seems out of date. For example:
+
+  yoo search pacman cache
+  yoo search --persona archie --since 2023-04-01 mirrorlist`,
+	Run: func(cmd *cobra.Command, args []string) {
+		query := strings.Join(args, " ")
+		pattern, err := termPattern(query)
+		checkError(err, "could not search for "+query, true)
+
+		index, err := refreshSearchIndex(viper.GetString("logpath"))
+		checkError(err, "could not update search index", true)
+
+		entries := []logEntry{}
+		for _, result := range index.search(query) {
+			entries = append(entries, logEntry{
+				Path:    filepath.Join(index.dir, result.Name),
+				Meta:    result.Log.Meta,
+				Size:    result.Log.Size,
+				ModTime: result.Log.ModTime,
+			})
+		}
+		entries, err = searchFilter.apply(entries)
+		checkError(err, "could not filter logs", true)
+		if len(entries) == 0 {
+			fmt.Println("no logs match " + query)
+			os.Exit(1)
+		}
+		if searchLimit > 0 && len(entries) > searchLimit {
+			entries = entries[:searchLimit]
+		}
+
+		highlight := highlighter(termutil.Isatty(os.Stdout.Fd()))
+		for i, entry := range entries {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Println(highlight(fmt.Sprintf("%s  %s  %s", entry.Date().Format("2006-01-02"), entry.Meta.Persona, entry.Title()), "1;34"))
+			fmt.Println(highlight(shortPath(entry.Path), "2"))
+			if snippet := searchSnippet(entry.Path, pattern, 160); snippet != "" {
+				fmt.Println("  " + replaceTerms(pattern, snippet, func(match string) string {
+					return highlight(match, "1;31")
+				}))
+			}
+		}
+	},
+}
+
+var (
+	searchFilter logFilter
+	searchLimit  int
+)
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// searchCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	searchCmd.Flags().IntVarP(&searchLimit, "limit", "n", 10, "the number of results to show, 0 for all")
+	searchCmd.Flags().StringVar(&searchFilter.persona, "persona", "", "only search logs with this persona")
+	searchCmd.Flags().StringVar(&searchFilter.since, "since", "", "only search logs from this date on (YYYY-MM-DD)")
+	searchCmd.Flags().StringVar(&searchFilter.until, "until", "", "only search logs up to this date (YYYY-MM-DD)")
+}
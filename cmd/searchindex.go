@@ -0,0 +1,474 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// searchIndexVersion is bumped whenever what's indexed changes, so older
+// indexes are rebuilt instead of read.
+const searchIndexVersion = 2
+
+// bm25 tuning, the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+const (
+	// searchLockWait is how long to wait for another yoo to finish updating
+	// the index.
+	searchLockWait = 5 * time.Second
+	// searchLockStale is how old a lock has to be to be taken as left over
+	// from a yoo that died.
+	searchLockStale = time.Minute
+)
+
+// searchIndex is an inverted index of the logs, kept in the log directory as
+// .search-index. It's only a cache: anything missing or stale is picked up
+// again from the logs themselves.
+type searchIndex struct {
+	Version int
+	// Docs are the indexed logs by file name.
+	Docs map[string]indexedLog
+	// Postings maps each term to the logs it appears in and how often.
+	Postings map[string]map[string]int
+
+	dir string
+}
+
+type indexedLog struct {
+	Meta    LogMeta
+	ModTime time.Time
+	Size    int64
+	// Length is the number of terms in the log.
+	Length int
+	// Terms are the distinct terms in the log, so it can be taken out of
+	// the postings without going through all of them.
+	Terms []string
+}
+
+// searchResult is a log matching a query.
+type searchResult struct {
+	Name  string
+	Log   indexedLog
+	Score float64
+}
+
+func searchIndexPath(dir string) string {
+	return filepath.Join(dir, ".search-index")
+}
+
+func newSearchIndex(dir string) *searchIndex {
+	return &searchIndex{
+		Version:  searchIndexVersion,
+		Docs:     map[string]indexedLog{},
+		Postings: map[string]map[string]int{},
+		dir:      dir,
+	}
+}
+
+// loadSearchIndex reads the index of the logs in dir. An index that is
+// missing, unreadable or from another version comes back empty, to be filled
+// in by refresh.
+func loadSearchIndex(dir string) *searchIndex {
+	file, err := os.Open(searchIndexPath(dir))
+	if err != nil {
+		return newSearchIndex(dir)
+	}
+	defer file.Close()
+	var index searchIndex
+	if err := gob.NewDecoder(file).Decode(&index); err != nil || index.Version != searchIndexVersion {
+		return newSearchIndex(dir)
+	}
+	index.dir = dir
+	return &index
+}
+
+// save writes the index atomically, so a reader never sees half of it.
+func (ix *searchIndex) save() error {
+	tmp, err := os.CreateTemp(ix.dir, ".search-index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(ix); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), searchIndexPath(ix.dir))
+}
+
+// add indexes a log, replacing what was indexed for it before.
+func (ix *searchIndex) add(name string, info fs.FileInfo) error {
+	path := filepath.Join(ix.dir, name)
+	var meta LogMeta
+	var text string
+	if l, err := readChatLog(path); err == nil {
+		meta, text = l.Meta, logText(l)
+	} else {
+		// logs that can't be parsed are still searchable by their content
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		text = string(content)
+	}
+
+	ix.remove(name)
+	terms := tokenize(text)
+	distinct := []string{}
+	for _, term := range terms {
+		postings, ok := ix.Postings[term]
+		if !ok {
+			postings = map[string]int{}
+			ix.Postings[term] = postings
+		}
+		if postings[name] == 0 {
+			distinct = append(distinct, term)
+		}
+		postings[name]++
+	}
+	ix.Docs[name] = indexedLog{Meta: meta, ModTime: info.ModTime(), Size: info.Size(), Length: len(terms), Terms: distinct}
+	return nil
+}
+
+func (ix *searchIndex) remove(name string) {
+	doc, ok := ix.Docs[name]
+	if !ok {
+		return
+	}
+	delete(ix.Docs, name)
+	for _, term := range doc.Terms {
+		postings := ix.Postings[term]
+		delete(postings, name)
+		if len(postings) == 0 {
+			delete(ix.Postings, term)
+		}
+	}
+}
+
+// refresh indexes logs that are new or changed since they were indexed and
+// drops the ones that are gone, saving the index if anything changed. It
+// returns how many logs were indexed or dropped.
+func (ix *searchIndex) refresh() (int, error) {
+	dirEntries, err := os.ReadDir(ix.dir)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	seen := map[string]bool{}
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil || !isLogFile(info) {
+			continue
+		}
+		seen[info.Name()] = true
+		doc, ok := ix.Docs[info.Name()]
+		if ok && doc.ModTime.Equal(info.ModTime()) && doc.Size == info.Size() {
+			continue
+		}
+		if err := ix.add(info.Name(), info); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	for name := range ix.Docs {
+		if !seen[name] {
+			ix.remove(name)
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	return changed, ix.save()
+}
+
+// search ranks the logs matching any term of query with bm25, best first.
+func (ix *searchIndex) search(query string) []searchResult {
+	if len(ix.Docs) == 0 {
+		return nil
+	}
+	totalLength := 0
+	for _, doc := range ix.Docs {
+		totalLength += doc.Length
+	}
+	avgLength := float64(totalLength) / float64(len(ix.Docs))
+
+	scores := map[string]float64{}
+	for _, term := range uniqueTerms(query) {
+		postings := ix.Postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		n := float64(len(postings))
+		idf := math.Log(1 + (float64(len(ix.Docs))-n+0.5)/(n+0.5))
+		for name, count := range postings {
+			tf := float64(count)
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(ix.Docs[name].Length)/avgLength)
+			scores[name] += idf * tf * (bm25K1 + 1) / (tf + norm)
+		}
+	}
+
+	results := []searchResult{}
+	for name, score := range scores {
+		results = append(results, searchResult{name, ix.Docs[name], score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Name > results[j].Name
+	})
+	return results
+}
+
+// refreshSearchIndex loads the index of the logs in dir and brings it up to
+// date, holding the lock so another yoo can't write it in between.
+func refreshSearchIndex(dir string) (*searchIndex, error) {
+	unlock, err := lockSearchIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	index := loadSearchIndex(dir)
+	_, err = index.refresh()
+	return index, err
+}
+
+// indexLog updates the search index once a log in the log directory is done
+// being written: at the end of a chat, or after `yoo uh`. Logs kept anywhere
+// else aren't indexed.
+func indexLog(path string) error {
+	dir := filepath.Dir(path)
+	if filepath.Clean(dir) != filepath.Clean(viper.GetString("logpath")) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockSearchIndex(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	index := loadSearchIndex(dir)
+	if err := index.add(filepath.Base(path), info); err != nil {
+		return err
+	}
+	return index.save()
+}
+
+// lockSearchIndex takes the lock on the index in dir, so two yoos updating it
+// at once don't lose each other's changes. It returns a func that lets it go.
+func lockSearchIndex(dir string) (func(), error) {
+	path := searchIndexPath(dir) + ".lock"
+	deadline := time.Now().Add(searchLockWait)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			// the lock is only removed while it's still this one, in case
+			// it was taken over as stale
+			holder := fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+			_, err := file.WriteString(holder)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return func() {
+				if content, err := os.ReadFile(path); err == nil && string(content) == holder {
+					os.Remove(path)
+				}
+			}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > searchLockStale {
+			takeOverStaleLock(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("another yoo is updating the search index, remove %s if none is running", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// takeOverStaleLock removes a left over lock. It's moved aside first, so
+// only one yoo gets it, and put back if another yoo took the lock since it
+// was found to be stale.
+func takeOverStaleLock(path string) {
+	aside := fmt.Sprintf("%s.%d-%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); err != nil {
+		return
+	}
+	defer os.Remove(aside)
+	if info, err := os.Stat(aside); err == nil && time.Since(info.ModTime()) <= searchLockStale {
+		// fails if yet another yoo has the lock, which is as good
+		os.Link(aside, path)
+	}
+}
+
+// logText is what's searchable in a log: its title and the conversation.
+func logText(l ChatLog) string {
+	parts := []string{l.Meta.Title}
+	for _, message := range l.History {
+		parts = append(parts, message.Content)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// tokenize splits text into lowercase words, leaving out single letters.
+func tokenize(text string) []string {
+	terms := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) > 1 {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+func uniqueTerms(text string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	for _, term := range tokenize(text) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// termPattern matches any of the query's terms as whole words, with the
+// characters around them. findTerms gives just the terms.
+func termPattern(query string) (*regexp.Regexp, error) {
+	terms := uniqueTerms(query)
+	if len(terms) == 0 {
+		return nil, errors.New("nothing to search for")
+	}
+	for i, term := range terms {
+		terms[i] = regexp.QuoteMeta(term)
+	}
+	// \b only knows ascii letters, so words are bounded by anything that
+	// isn't a letter or digit in any script, like tokenize splits them
+	return regexp.Compile(`(?i)(?:^|[^\p{L}\p{N}_])(` + strings.Join(terms, "|") + `)(?:$|[^\p{L}\p{N}_])`)
+}
+
+// findTerms returns where termPattern's terms are in text. A match takes
+// the character after the term with it, so each search starts again right
+// after the term, where the next one can start.
+func findTerms(pattern *regexp.Regexp, text string) [][]int {
+	found := [][]int{}
+	for at := 0; at < len(text); {
+		match := pattern.FindStringSubmatchIndex(text[at:])
+		if match == nil {
+			break
+		}
+		found = append(found, []int{at + match[2], at + match[3]})
+		at += match[3]
+	}
+	return found
+}
+
+// replaceTerms replaces each of termPattern's terms in text with what
+// replace returns for it.
+func replaceTerms(pattern *regexp.Regexp, text string, replace func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range findTerms(pattern, text) {
+		b.WriteString(text[last:match[0]])
+		b.WriteString(replace(text[match[0]:match[1]]))
+		last = match[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// searchSnippet is the part of a log around its best match for pattern:
+// the message with the most matches, cut to about width characters on one
+// line.
+func searchSnippet(path string, pattern *regexp.Regexp, width int) string {
+	texts := []string{}
+	if l, err := readChatLog(path); err == nil {
+		for _, message := range l.History {
+			if message.Role != openai.ChatMessageRoleSystem {
+				texts = append(texts, message.Content)
+			}
+		}
+	} else if content, err := os.ReadFile(path); err == nil {
+		texts = append(texts, string(content))
+	}
+
+	best, bestCount := "", 0
+	for _, text := range texts {
+		if count := len(findTerms(pattern, text)); count > bestCount {
+			best, bestCount = text, count
+		}
+	}
+	if bestCount == 0 {
+		return ""
+	}
+
+	text := strings.Join(strings.Fields(best), " ")
+	match := findTerms(pattern, text)[0]
+	runes := []rune(text)
+	at := len([]rune(text[:match[0]]))
+	start := at - width/3
+	if start < 0 {
+		start = 0
+	}
+	end := start + width
+	if end > len(runes) {
+		end = len(runes)
+	}
+	snippet := string(runes[start:end])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
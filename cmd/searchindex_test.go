@@ -0,0 +1,147 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSearchRanking(t *testing.T) {
+	dir := testConfig(t, nil)
+	logs := map[string]string{
+		"cache.md":   "pacman cache, clearing the pacman cache with paccache",
+		"mirrors.md": "pacman mirrors are slow, rank the mirrors with reflector",
+		"vim.md":     "vim config tips and plugins for go",
+		"zsh.md":     "zsh prompt",
+		"shells.md":  "comparing zsh with bash and fish, their prompts, completion, history, plugins and startup time",
+	}
+	for name, content := range logs {
+		if err := os.WriteFile(dir+name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := loadSearchIndex(dir)
+	if count, err := index.refresh(); err != nil || count != len(logs) {
+		t.Fatalf("indexed %d logs (%v), want %d", count, err, len(logs))
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"more matches rank higher", "pacman", []string{"cache.md", "mirrors.md"}},
+		{"rare terms count for more", "pacman mirrors", []string{"mirrors.md", "cache.md"}},
+		{"shorter logs rank higher", "zsh", []string{"zsh.md", "shells.md"}},
+		{"any term matches", "vim fish", []string{"vim.md", "shells.md"}},
+		{"case and punctuation are ignored", "PACCACHE!", []string{"cache.md"}},
+		{"no matches", "emacs", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []string{}
+			for _, result := range index.search(test.query) {
+				got = append(got, result.Name)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("search %q found %v, want %v", test.query, got, test.want)
+			}
+		})
+	}
+
+	// removed logs are dropped, and the index is read back the same
+	if err := os.Remove(dir + "mirrors.md"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := index.refresh(); err != nil || count != 1 {
+		t.Fatalf("refreshed %d logs (%v), want 1", count, err)
+	}
+	for term, postings := range loadSearchIndex(dir).Postings {
+		if _, ok := postings["mirrors.md"]; ok {
+			t.Errorf("%s still lists the removed log", term)
+		}
+	}
+	if results := loadSearchIndex(dir).search("mirrors"); len(results) != 0 {
+		t.Errorf("found the removed log: %v", results)
+	}
+}
+
+func TestTermPattern(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		text  string
+		want  string
+	}{
+		{"whole words", "cache", "pacman cache, paccache", "pacman [cache], paccache"},
+		{"next to each other", "zsh", "zsh zsh", "[zsh] [zsh]"},
+		{"any term", "vim fish", "fish or vim", "[fish] or [vim]"},
+		{"letters outside ascii", "café", "un café, cafés", "un [café], cafés"},
+		{"not inside words outside ascii", "caf", "café", "café"},
+		{"other scripts", "пакет", "пакет пакеты", "[пакет] пакеты"},
+		{"underscores join words", "cache", "cache_dir", "cache_dir"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pattern, err := termPattern(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := replaceTerms(pattern, test.text, func(term string) string { return "[" + term + "]" })
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLockSearchIndex(t *testing.T) {
+	dir := testConfig(t, nil)
+	lock := searchIndexPath(dir) + ".lock"
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * searchLockStale)
+	if err := os.Chtimes(lock, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := lockSearchIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(lock); err != nil || info.ModTime().Equal(stale) {
+		t.Fatalf("the stale lock wasn't taken over: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("left %d files, want just the lock", len(entries))
+	}
+
+	// a lock taken over from this one is left to its new holder
+	os.Remove(lock)
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if _, err := os.Stat(lock); err != nil {
+		t.Error("removed another yoo's lock")
+	}
+}
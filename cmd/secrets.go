@@ -0,0 +1,312 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// openAIKeySecret is the name of the api key used by openai personas.
+const openAIKeySecret = "openai-key"
+
+// keyringService is what yoo's secrets are stored under in the keyring.
+const keyringService = "yoo"
+
+// keyringBackend is the part of the OS keyring yoo uses. It's a variable so
+// the Secret Service can be swapped for something else where there is no
+// D-Bus session.
+type keyringBackend interface {
+	Get(service string, user string) (string, error)
+	Set(service string, user string, password string) error
+	Delete(service string, user string) error
+}
+
+type systemKeyring struct{}
+
+func (systemKeyring) Get(service string, user string) (string, error) {
+	return keyring.Get(service, user)
+}
+
+func (systemKeyring) Set(service string, user string, password string) error {
+	return keyring.Set(service, user, password)
+}
+
+func (systemKeyring) Delete(service string, user string) error {
+	return keyring.Delete(service, user)
+}
+
+var secretKeyring keyringBackend = systemKeyring{}
+
+// secretSource is somewhere a secret can be read from. get returns "" when
+// the source doesn't have it. Errors from optional sources, which may not be
+// there at all, are skipped over when resolving secrets.
+type secretSource struct {
+	name     string
+	optional bool
+	get      func(secret string, persona Persona) (string, error)
+}
+
+// secretSources are tried in order, and the first to have the secret wins.
+var secretSources = []secretSource{
+	{"env", false, func(secret string, persona Persona) (string, error) {
+		if value := os.Getenv(secretEnv(secret)); value != "" {
+			return value, nil
+		}
+		if secret == openAIKeySecret {
+			return os.Getenv("OPENAI_API_KEY"), nil
+		}
+		return "", nil
+	}},
+	{"key-command", false, func(secret string, persona Persona) (string, error) {
+		if secret != openAIKeySecret {
+			return "", nil
+		}
+		return runKeyCommand(personaSetting(persona, "key-command"))
+	}},
+	{"keyring", true, func(secret string, persona Persona) (string, error) {
+		value, err := secretKeyring.Get(keyringService, secret)
+		if errors.Is(err, keyring.ErrNotFound) {
+			return "", nil
+		}
+		return value, err
+	}},
+	{"encrypted file", false, func(secret string, persona Persona) (string, error) {
+		secrets, err := readSecretsFile()
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return secrets[secret], nil
+	}},
+	{"config", false, func(secret string, persona Persona) (string, error) {
+		return viper.GetString("secrets." + secret), nil
+	}},
+}
+
+// secretEnv is the environment variable that overrides a secret, e.g.
+// YOO_OPENAI_KEY.
+func secretEnv(secret string) string {
+	return "YOO_" + strings.ToUpper(strings.ReplaceAll(secret, "-", "_"))
+}
+
+// resolveSecret finds a secret for persona, returning it along with the name
+// of the source it came from. A missing secret is "", not an error.
+func resolveSecret(secret string, persona Persona) (string, string, error) {
+	for _, source := range secretSources {
+		value, err := source.get(secret, persona)
+		if err != nil && source.optional {
+			continue
+		}
+		if err != nil {
+			return "", source.name, fmt.Errorf("could not read %s from %s: %w", secret, source.name, err)
+		}
+		if value != "" {
+			return value, source.name, nil
+		}
+	}
+	return "", "", nil
+}
+
+// keyCommandCache holds what each key-command printed, so it runs once per
+// process however many providers are built, and a passphrase is only asked
+// for once.
+var keyCommandCache = struct {
+	sync.Mutex
+	keys map[string]string
+}{keys: map[string]string{}}
+
+// runKeyCommand runs a `key-command:` like `pass show openai` and returns the
+// first line it prints.
+func runKeyCommand(command string) (string, error) {
+	if command == "" {
+		return "", nil
+	}
+	keyCommandCache.Lock()
+	defer keyCommandCache.Unlock()
+	if key, ok := keyCommandCache.keys[command]; ok {
+		return key, nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%s: %w: %s", command, err, message)
+		}
+		return "", fmt.Errorf("%s: %w", command, err)
+	}
+	line, _, _ := strings.Cut(string(output), "\n")
+	key := strings.TrimSpace(line)
+	keyCommandCache.keys[command] = key
+	return key, nil
+}
+
+// secretsFile holds secrets encrypted with AES-GCM, under a key derived from
+// a passphrase with scrypt.
+type secretsFile struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+func secretsFilePath() string {
+	if path := viper.GetString("secrets-file"); path != "" {
+		return path
+	}
+	return viper.GetString("configpath") + "secrets.enc"
+}
+
+// secretsPassphrase is asked for once per run.
+var secretsPassphrase string
+
+// readPassphrase takes the passphrase for the secrets file from
+// YOO_SECRETS_PASSPHRASE, or else asks for it on the terminal.
+func readPassphrase(confirm bool) (string, error) {
+	if secretsPassphrase != "" {
+		return secretsPassphrase, nil
+	}
+	if passphrase := os.Getenv("YOO_SECRETS_PASSPHRASE"); passphrase != "" {
+		secretsPassphrase = passphrase
+		return passphrase, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("no terminal to ask for the passphrase on, set YOO_SECRETS_PASSPHRASE")
+	}
+	defer tty.Close()
+	ask := func(prompt string) (string, error) {
+		fmt.Fprint(tty, prompt)
+		passphrase, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(tty)
+		return string(passphrase), err
+	}
+	passphrase, err := ask("passphrase for " + secretsFilePath() + ": ")
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		again, err := ask("again: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("passphrases don't match")
+		}
+	}
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	secretsPassphrase = passphrase
+	return passphrase, nil
+}
+
+func secretsCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readSecretsFile decrypts the secrets file.
+func readSecretsFile() (map[string]string, error) {
+	content, err := os.ReadFile(secretsFilePath())
+	if err != nil {
+		return nil, err
+	}
+	var file secretsFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", secretsFilePath(), err)
+	}
+	passphrase, err := readPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := secretsCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		secretsPassphrase = ""
+		return nil, errors.New("wrong passphrase, or the file is damaged")
+	}
+	secrets := map[string]string{}
+	return secrets, json.Unmarshal(plain, &secrets)
+}
+
+// writeSecretsFile encrypts secrets into the secrets file, with a fresh salt
+// and nonce. An empty map removes the file.
+func writeSecretsFile(secrets map[string]string, confirm bool) error {
+	if len(secrets) == 0 {
+		err := os.Remove(secretsFilePath())
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	passphrase, err := readPassphrase(confirm)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	file := secretsFile{Salt: make([]byte, 16)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	aead, err := secretsCipher(passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Data = aead.Seal(nil, file.Nonce, plain, nil)
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(secretsFilePath(), content, 0600)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// setCmd represents the set command
+var setCmd = &cobra.Command{
+	Args:  cobra.ExactArgs(2),
+	Use:   "set <key> <value>",
+	Short: "Set a config value",
+	Long: `Set a config value by its dotted key, saving it to the config file. Values are
+read as yaml, so true/false, numbers and [lists] keep their types; use --string
+to store one as text. For example:
+
+  yoo config set persona archie
+  yoo config set personas.archie.temperature 0.2
+  yoo config set personas.archie.stop '["###", "---"]'
+  yoo config set 'models."gpt-3.5-turbo".context-window' 16385`,
+	Run: func(cmd *cobra.Command, args []string) {
+		keys, err := splitConfigKey(args[0])
+		checkError(err, "invalid key "+args[0], true)
+		node := parseConfigValue(args[1])
+		if setString {
+			node = &yaml.Node{}
+			node.SetString(args[1])
+		}
+		checkError(setConfigNode(keys, node), "could not set "+args[0], true)
+		if !viper.GetBool("quiet") {
+			value, _ := lookupConfigValue(keys)
+			if isSecretKey(keys) {
+				value = maskSecret(fmt.Sprint(value))
+			}
+			fmt.Println(joinConfigKey(keys) + " = " + formatConfigValue(value))
+		}
+	},
+}
+
+var setString bool
+
+func init() {
+	configCmd.AddCommand(setCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// setCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	setCmd.Flags().BoolVar(&setString, "string", false, "store the value as a string")
+}
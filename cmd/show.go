@@ -0,0 +1,88 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showCmd represents the show command
+var showCmd = &cobra.Command{
+	Args:  cobra.ExactArgs(1),
+	Use:   "show <name>",
+	Short: "Print a persona",
+	Long: `Print a persona's file. With --resolved, print the persona as it is used
+instead: with the persona it extends and the files it includes folded in, and
+its template rendered, as a persona file of its own. For example:
+
+  yoo persona show archie
+  yoo persona show --resolved archie`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if !showResolved {
+			_, _, path, err := readPersonaFile(name)
+			checkError(err, "could not read persona "+name, true)
+			content, err := os.ReadFile(path)
+			checkError(err, "could not read persona "+name, true)
+			if !viper.GetBool("quiet") {
+				fmt.Println("# " + shortPath(path) + "\n")
+			}
+			fmt.Print(string(content))
+			return
+		}
+
+		stubTemplateHelpers = true
+		resolved, err := resolvePersona(name, nil)
+		checkError(err, "could not load persona "+name, true)
+		settings := resolved.personaFile
+		settings.Extends, settings.Include = "", nil
+		frontMatter, err := encodeYAML(settings)
+		checkError(err, "could not print persona "+name, true)
+
+		fmt.Println("---")
+		for _, source := range resolved.Sources {
+			fmt.Println("# from " + shortPath(source))
+		}
+		if string(frontMatter) != "{}\n" {
+			fmt.Print(string(frontMatter))
+		}
+		fmt.Println("---")
+		persona := Persona{Name: name, Model: resolved.Model, Provider: resolved.Provider}
+		fmt.Println(strings.TrimSuffix(renderSystemPrompt(persona, resolved), "\n"))
+	},
+}
+
+var showResolved bool
+
+func init() {
+	personasCmd.AddCommand(showCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// showCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	showCmd.Flags().BoolVar(&showResolved, "resolved", false, "print the persona with what it extends and includes folded in")
+}
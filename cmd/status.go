@@ -0,0 +1,89 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Args:  cobra.MaximumNArgs(1),
+	Use:   "status [persona]",
+	Short: "Show where the api key comes from",
+	Long: `Show each place the api key can come from, in order, and which one is used
+for a persona (the default persona if none is given). For example:
+
+  yoo auth status`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := viper.GetString("persona")
+		if len(args) > 0 {
+			name = args[0]
+		}
+		persona, err := loadPersona(name)
+		if err != nil {
+			persona = Persona{Name: name}
+		}
+
+		used := ""
+		for _, source := range secretSources {
+			status := "not set"
+			if source.name == "encrypted file" && used != "" {
+				// don't ask for a passphrase to show a key that isn't used
+				if _, err := os.Stat(secretsFilePath()); !errors.Is(err, fs.ErrNotExist) {
+					status = "not read"
+				}
+			} else if value, err := source.get(openAIKeySecret, persona); err != nil {
+				status = "error: " + err.Error()
+				if !source.optional && used == "" {
+					// resolving the key stops here too
+					used = source.name
+				}
+			} else if value != "" {
+				status = maskSecret(value)
+				if used == "" {
+					used = source.name
+					status += " (used)"
+				}
+			}
+			fmt.Printf("%-15s %s\n", source.name, status)
+		}
+		if used == "" {
+			fmt.Println("\nno api key found, set one with `yoo auth login`")
+		}
+	},
+}
+
+func init() {
+	authCmd.AddCommand(statusCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// statusCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// statusCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
@@ -0,0 +1,199 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// templateVars are the --var key=value flags, set over the `vars:` in config.
+var templateVars []string
+
+// templateHelperTimeout bounds how long an sh helper may run.
+const templateHelperTimeout = 10 * time.Second
+
+// stubTemplateHelpers is set by commands that only check or print personas,
+// like doctor and `personas show --resolved`, so looking at a persona never
+// reads files or runs commands. The helpers render as placeholders instead.
+var stubTemplateHelpers bool
+
+// renderSystemPrompt runs a system prompt through text/template. Prompts
+// that aren't templates, or that fail to render, are used as they are with
+// a warning, since plenty of prompts talk about templates themselves.
+func renderSystemPrompt(persona Persona, resolved resolvedPersona) string {
+	prompt := resolved.System
+	if !strings.Contains(prompt, "{{") {
+		return prompt
+	}
+	rendered, err := renderTemplate(persona, prompt, resolved)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning: using the system prompt of "+persona.Name+" as it is, it could not be rendered: "+err.Error())
+		return prompt
+	}
+	return rendered
+}
+
+func renderTemplate(persona Persona, prompt string, resolved resolvedPersona) (string, error) {
+	tmpl, err := template.New(persona.Name).
+		Option("missingkey=error").
+		Funcs(templateHelpers(resolved)).
+		Parse(prompt)
+	if err != nil {
+		return "", err
+	}
+	data, err := templateData(persona)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// templateData are the variables a system prompt can use: the built in
+// ones, then `vars:` from config, then --var flags.
+func templateData(persona Persona) (map[string]any, error) {
+	now := time.Now().Local()
+	cwd, _ := os.Getwd()
+	data := map[string]any{
+		"date":    now.Format("2006-01-02"),
+		"time":    now.Format("15:04"),
+		"cwd":     cwd,
+		"branch":  gitBranch(),
+		"os":      osName(),
+		"shell":   filepath.Base(os.Getenv("SHELL")),
+		"user":    "",
+		"persona": persona.Name,
+		"model":   persona.Model,
+	}
+	if current, err := user.Current(); err == nil {
+		data["user"] = current.Username
+	}
+	for key, value := range viper.GetStringMap("vars") {
+		data[key] = value
+	}
+	for _, flag := range templateVars {
+		key, value, ok := strings.Cut(flag, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("--var %q should look like key=value", flag)
+		}
+		data[key] = value
+	}
+	return data, nil
+}
+
+// gitBranch is the branch checked out in the working directory, if any.
+func gitBranch() string {
+	output, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// osName is the pretty name of the distribution on linux, like "Arch Linux",
+// and the GOOS elsewhere.
+func osName() string {
+	content, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return runtime.GOOS
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if name, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+			return strings.Trim(name, `"`)
+		}
+	}
+	return runtime.GOOS
+}
+
+// templateHelpers are the functions a system prompt can call. file and sh
+// read files and run commands, so they only work once they're listed in
+// `template-helpers:` in the user config, and only for prompts from your own
+// files or from projects you trust.
+func templateHelpers(resolved resolvedPersona) template.FuncMap {
+	enabled := map[string]bool{}
+	for _, helper := range viper.GetStringSlice("template-helpers") {
+		enabled[helper] = true
+	}
+	notEnabled := func(helper string) error {
+		return fmt.Errorf("the %s helper is not enabled, add it to template-helpers in %s", helper, configFilePath())
+	}
+	// a project persona can shadow one of yours, and a project can pick the
+	// files of your prompts, so prompts with any file from a project, or any
+	// file a project picked, only get the helpers once you trust it
+	distrust := func(source string) {
+		enabled = map[string]bool{}
+		notEnabled = func(helper string) error {
+			return fmt.Errorf("the %s helper can't be used by %s until you trust the project with `yoo project trust`", helper, shortPath(source))
+		}
+	}
+	for _, p := range resolved.ChosenBy {
+		if !p.Trusted {
+			distrust(p.Dir)
+		}
+	}
+	for _, source := range resolved.Sources {
+		if p, ok := projectUnder(source); ok && !p.Trusted {
+			distrust(source)
+		}
+	}
+
+	return template.FuncMap{
+		"file": func(path string) (string, error) {
+			if !enabled["file"] {
+				return "", notEnabled("file")
+			}
+			if stubTemplateHelpers {
+				return "[file " + path + "]", nil
+			}
+			content, err := os.ReadFile(path)
+			return strings.TrimRight(string(content), "\n"), err
+		},
+		"sh": func(command string) (string, error) {
+			if !enabled["sh"] {
+				return "", notEnabled("sh")
+			}
+			if stubTemplateHelpers {
+				return "[sh " + command + "]", nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), templateHelperTimeout)
+			defer cancel()
+			var stderr bytes.Buffer
+			cmd := exec.CommandContext(ctx, "sh", "-c", command)
+			cmd.Stderr = &stderr
+			output, err := cmd.Output()
+			if err != nil {
+				return "", fmt.Errorf("%s: %w: %s", command, err, strings.TrimSpace(stderr.String()))
+			}
+			return strings.TrimRight(string(output), "\n"), nil
+		},
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestTemplateHelpersInProjects(t *testing.T) {
+	tests := []struct {
+		name string
+		// files are written under the test directory, which holds the
+		// user config in home/ and a cloned repo in repo/
+		files   map[string]string
+		trusted bool
+		// runs is whether the sh helper in repo/evil.md gets to run
+		runs bool
+	}{
+		{
+			name: "project config includes a repo file in your persona",
+			files: map[string]string{
+				"repo/.yoo/config.yml": "personas:\n  archie:\n    include: [../../repo/evil.md]\n",
+			},
+		},
+		{
+			name: "your persona includes a repo file",
+			files: map[string]string{
+				"home/archie.md": "---\ninclude: [../repo/evil.md]\n---\nyou are archie",
+			},
+		},
+		{
+			name: "trusted project config includes a repo file in your persona",
+			files: map[string]string{
+				"repo/.yoo/config.yml": "personas:\n  archie:\n    include: [evil.md]\n",
+			},
+			trusted: true,
+			runs:    true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := testConfig(t, nil)
+			files := map[string]string{
+				"home/archie.md":       "you are archie",
+				"repo/evil.md":         `{{sh "touch ` + dir + `ran"}}`,
+				"repo/.yoo/config.yml": "",
+			}
+			for name, content := range test.files {
+				files[name] = content
+			}
+			for name, content := range files {
+				os.MkdirAll(filepath.Dir(dir+name), 0755)
+				if err := os.WriteFile(dir+name, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			viper.Set("configpath", dir+"home/")
+			viper.Set("template-helpers", []string{"sh"})
+			if test.trusted {
+				viper.Set("trusted-projects", []string{dir + "repo"})
+			}
+			wd, _ := os.Getwd()
+			if err := os.Chdir(dir + "repo"); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.Chdir(wd) })
+			readConfig()
+
+			if _, err := loadPersona("archie"); err != nil {
+				t.Fatal(err)
+			}
+			if ran := fileExists(dir + "ran"); ran != test.runs {
+				t.Errorf("ran the command: %t, want %t", ran, test.runs)
+			}
+		})
+	}
+}
+
+func TestStubTemplateHelpers(t *testing.T) {
+	dir := testConfig(t, nil)
+	prompt := `you are archie {{sh "touch ` + dir + `ran"}}`
+	if err := os.WriteFile(dir+"archie.md", []byte(prompt), 0644); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("template-helpers", []string{"sh"})
+	stubTemplateHelpers = true
+	t.Cleanup(func() { stubTemplateHelpers = false })
+
+	persona, err := loadPersona("archie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(dir + "ran") {
+		t.Error("ran the command")
+	}
+	if want := "you are archie [sh touch " + dir + "ran]"; persona.SystemMessage.Content != want {
+		t.Errorf("got %q, want %q", persona.SystemMessage.Content, want)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// trustCmd represents the trust command
+var trustCmd = &cobra.Command{
+	Args:  cobra.MaximumNArgs(1),
+	Use:   "trust [dir]",
+	Short: "Let a project choose where its prompts are sent",
+	Long: `Trust the nearest project, or the one in dir, so its config.yml can set keys
+like base-url, endpoint, headers, provider, recall and knowledge. Only trust
+repos whose .yoo/ you've read: these decide where your prompts and api key go.
+Trusted projects are kept under trusted-projects in your own config. For example:
+
+  yoo project trust
+  yoo project trust ~/src/infra`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := ""
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		root, err := projectRoot(dir)
+		checkError(err, "could not find the project", true)
+		if isTrustedProject(root) {
+			fmt.Println(shortPath(root) + " is already trusted")
+			return
+		}
+		trusted := append(viper.GetStringSlice("trusted-projects"), root)
+		checkError(setConfigValue([]string{"trusted-projects"}, trusted), "could not trust "+root, true)
+		if !viper.GetBool("quiet") {
+			fmt.Println("trusted " + shortPath(root))
+		}
+	},
+}
+
+func init() {
+	projectCmd.AddCommand(trustCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// trustCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// trustCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
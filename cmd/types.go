@@ -7,12 +7,58 @@ import (
 type Persona struct {
 	Name          string
 	Model         string
+	Provider      string
+	Params        ModelParams
 	SystemMessage openai.ChatCompletionMessage
+	// Examples are worked user and assistant turns shown to the model before
+	// the conversation.
+	Examples []openai.ChatCompletionMessage
+	// Knowledge are the directories of documents the persona answers from.
+	Knowledge []string
+}
+
+// ModelParams are the sampling settings a persona sends with its requests.
+// Unset pointers and zero values are left to the model's defaults.
+type ModelParams struct {
+	Temperature      *float32 `yaml:"temperature,omitempty"`
+	TopP             *float32 `yaml:"top_p,omitempty"`
+	MaxTokens        int      `yaml:"max_tokens,omitempty"`
+	Stop             []string `yaml:"stop,omitempty"`
+	PresencePenalty  *float32 `yaml:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `yaml:"frequency_penalty,omitempty"`
+	Seed             *int     `yaml:"seed,omitempty"`
+}
+
+// Messages returns the full message list to send for this persona: the system
+// message, then the examples, then the history.
+func (p Persona) Messages(history []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	messages := []openai.ChatCompletionMessage{p.SystemMessage}
+	messages = append(messages, p.Examples...)
+	return append(messages, history...)
+}
+
+// Usage is the token count of a request, or of a whole conversation.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens" yaml:"prompt-tokens"`
+	CompletionTokens int `json:"completion_tokens" yaml:"completion-tokens"`
+	// Estimated is set when the provider didn't report usage and it was
+	// counted locally instead.
+	Estimated bool `json:"estimated,omitempty" yaml:"estimated,omitempty"`
+}
+
+// Add sums two usages.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		Estimated:        u.Estimated || other.Estimated,
+	}
 }
 
 type LoadedResources struct {
-	OpenAIClient *openai.Client
-	ChatPersona  Persona
-	TitlePersona Persona
-	LogDir       string
+	ChatPersona   Persona
+	ChatProvider  Provider
+	TitlePersona  Persona
+	TitleProvider Provider
+	LogDir        string
 }
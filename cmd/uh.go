@@ -18,9 +18,12 @@ package cmd
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	termutil "github.com/andrew-d/go-termutil"
@@ -47,6 +50,8 @@ to quickly create a Cobra application.`,
 		if len(args) > 0 {
 			userPrompt += args[0]
 		}
+		// only what was typed can @mention files
+		typedPrompt := userPrompt
 
 		// if stdin was provided, add that to prompt
 		if !termutil.Isatty(os.Stdin.Fd()) {
@@ -58,17 +63,30 @@ to quickly create a Cobra application.`,
 			}
 		}
 
-		// set up personas
-		chatPersonaName := viper.GetString("persona")
-		chatSystemPrompt, file, err := loadSystemPrompt(chatPersonaName)
-		checkError(err, "system prompt file could not be read: "+file, true)
-		chatPersona := Persona{
-			chatPersonaName,
-			viper.GetString("personas." + chatPersonaName + ".model"),
-			openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleSystem,
-				Content: chatSystemPrompt,
-			},
+		// set up personas and providers
+		resources := loadResources()
+		chatPersona := resources.ChatPersona
+		chatLog := newChatLog(chatPersona)
+		// recalled answers and knowledge are sent along but not logged
+		notice := func(message string) {
+			if !viper.GetBool("quiet") {
+				fmt.Fprintln(os.Stderr, "("+message+")")
+			}
+		}
+		chatPersona = withRecall(context.Background(), chatPersona, userPrompt, "", notice)
+		chatPersona = withKnowledge(context.Background(), chatPersona, userPrompt, notice)
+
+		// attached files are part of the prompt, but the log is titled by
+		// what was asked
+		titlePrompt := userPrompt
+		userPrompt, err := attachFiles(chatPersona, userPrompt, typedPrompt, attachFlags, notice)
+		if errors.Is(err, errNotSent) {
+			fmt.Println("not sent")
+			return
+		}
+		checkError(err, "could not attach files", true)
+		if strings.TrimSpace(titlePrompt) == "" {
+			titlePrompt = userPrompt
 		}
 
 		// print something for ux
@@ -80,58 +98,40 @@ to quickly create a Cobra application.`,
 			s.Start()
 		}
 
-		// set up an openai client
-		openAIClient := openai.NewClient(viper.GetString("secrets.openai-key"))
-
-		// get the main prompt response
-		promptResponse, err := createChatCompletion(
-			openAIClient,
+		// stream the main prompt response out to console
+		prefix := ""
+		if !viper.GetBool("quiet") {
+			prefix = "╰─ "
+		}
+		promptResponse, usage, err := streamChatCompletion(
+			context.Background(),
+			resources.ChatProvider,
 			chatPersona,
 			userPrompt,
-			[]openai.ChatCompletionMessage{})
-		checkError(err, "could not complete request to openai", true)
+			[]openai.ChatCompletionMessage{},
+			s,
+			prefix)
+		checkError(err, "could not complete request to "+chatPersona.Name, true)
 
-		// write response out to console
-		if s.Active() {
-			s.Stop()
-		}
-		output := promptResponse
-		if !viper.GetBool("quiet") {
-			output = "╰─ " + output
-		}
-		fmt.Println(output)
-
-		// set up title persona
-		titlePersonaName := viper.GetString("title-persona")
-		titleSystemPrompt, file, err := loadSystemPrompt(titlePersonaName)
-		checkError(err, "system prompt file could not be read: "+file, true)
-		titlePersona := Persona{
-			titlePersonaName,
-			viper.GetString("personas." + titlePersonaName + ".model"),
+		// log response to file
+		chatLog.History = append(
+			chatLog.History,
 			openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleSystem,
-				Content: titleSystemPrompt,
+				Role:    openai.ChatMessageRoleUser,
+				Content: userPrompt,
 			},
-		}
-
-		// get title content
-		titleContent := div("system") + chatPersona.SystemMessage.Content + div("prompt") + userPrompt
-		title, err := createChatCompletion(
-			openAIClient,
-			titlePersona,
-			titleContent,
-			[]openai.ChatCompletionMessage{})
-		checkError(err, "could not complete request to openai for title slug", false)
-		if title == "" {
-			title = "unknown-topic"
-		}
-
-		// log response to file
-		currentTime := time.Now().Local().Format("2006-01-02--15-04-05-MST")
-		logName := viper.GetString("logpath") + currentTime + "." + title + ".md"
-		metaContent := "# " + title + "\n\n" + currentTime
-		content := metaContent + div("user") + userPrompt + div(chatPersona.Name) + promptResponse + div("system") + chatPersona.SystemMessage.Content
-		os.WriteFile(logName, []byte(content), 0644)
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: promptResponse,
+			},
+		)
+		chatLog.Meta.Usage = usage
+		chatLog.Meta.Title = generateTitle(resources, titlePrompt)
+		err = writeChatLog(resources.LogDir+chatLog.FileName(), chatLog)
+		checkError(err, "could not write log", false)
+		// the index is only a cache, a search or `yoo index rebuild` catches
+		// up if this fails
+		indexLog(resources.LogDir + chatLog.FileName())
 	},
 }
 
@@ -147,5 +147,6 @@ func init() {
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
-	// uhCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	uhCmd.Flags().StringArrayVarP(&attachFlags.files, "file", "f", []string{}, "file to add to the prompt (repeatable)")
+	uhCmd.Flags().StringArrayVar(&attachFlags.globs, "glob", []string{}, "files to add to the prompt, ** matches any directories (repeatable)")
 }
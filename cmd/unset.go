@@ -0,0 +1,60 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// unsetCmd represents the unset command
+var unsetCmd = &cobra.Command{
+	Args:  cobra.ExactArgs(1),
+	Use:   "unset <key>",
+	Short: "Remove a config value",
+	Long: `Remove a config value, or a whole section, from the config file. For example:
+
+  yoo config unset personas.archie.temperature`,
+	Run: func(cmd *cobra.Command, args []string) {
+		keys, err := splitConfigKey(args[0])
+		checkError(err, "invalid key "+args[0], true)
+		found, err := unsetConfigValue(keys)
+		checkError(err, "could not unset "+args[0], true)
+		if !found {
+			checkError(fmt.Errorf("%s is not set in %s", args[0], configFilePath()), "could not unset "+args[0], true)
+		}
+		if !viper.GetBool("quiet") {
+			fmt.Println("unset " + joinConfigKey(keys))
+		}
+	},
+}
+
+func init() {
+	configCmd.AddCommand(unsetCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// unsetCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// unsetCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// untrustCmd represents the untrust command
+var untrustCmd = &cobra.Command{
+	Args:  cobra.MaximumNArgs(1),
+	Use:   "untrust [dir]",
+	Short: "Stop trusting a project",
+	Long: `Take the nearest project, or the one in dir, off trusted-projects, so its
+config.yml can no longer choose where prompts are sent. For example:
+
+  yoo project untrust
+  yoo project untrust ~/src/infra`,
+	Run: func(cmd *cobra.Command, args []string) {
+		root := ""
+		if len(args) > 0 {
+			// the project may be gone already, so it isn't looked for
+			abs, err := filepath.Abs(expandHome(args[0]))
+			checkError(err, "invalid directory "+args[0], true)
+			root = abs
+			if filepath.Base(root) == projectDirName {
+				root = filepath.Dir(root)
+			}
+		} else {
+			found, err := projectRoot("")
+			checkError(err, "could not find the project", true)
+			root = found
+		}
+		if !isTrustedProject(root) {
+			fmt.Println(shortPath(root) + " isn't trusted")
+			return
+		}
+		trusted := []string{}
+		for _, dir := range viper.GetStringSlice("trusted-projects") {
+			if filepath.Clean(expandHome(dir)) != filepath.Clean(root) {
+				trusted = append(trusted, dir)
+			}
+		}
+		checkError(setConfigValue([]string{"trusted-projects"}, trusted), "could not untrust "+root, true)
+		if !viper.GetBool("quiet") {
+			fmt.Println("no longer trusting " + shortPath(root))
+		}
+	},
+}
+
+func init() {
+	projectCmd.AddCommand(untrustCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// untrustCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// untrustCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
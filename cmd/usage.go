@@ -0,0 +1,159 @@
+/*
+Copyright © 2023 Zak Reynolds <zak.reynolds@zakjr.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// usageCmd represents the usage command
+var usageCmd = &cobra.Command{
+	Args:  cobra.NoArgs,
+	Use:   "usage",
+	Short: "Report token usage and spend",
+	Long: `Report the tokens used and money spent on requests, including the ones made to
+title logs, grouped by day, persona and model. Prices come from the config, per
+million tokens:
+
+  models:
+    gpt-4:
+      price:
+        prompt: 30
+        completion: 60
+
+For example:
+
+  yoo usage
+  yoo usage --by persona --since 2023-04-01`,
+	Run: func(cmd *cobra.Command, args []string) {
+		since := time.Now().Local().AddDate(0, 0, -usageDays)
+		if usageSince != "" {
+			parsed, err := time.ParseInLocation("2006-01-02", usageSince, time.Local)
+			checkError(err, "--since must look like 2023-04-01", true)
+			since = parsed
+		}
+		records, err := readUsage(since)
+		checkError(err, "could not read usage ledger", true)
+		if len(records) == 0 {
+			fmt.Println("no usage since " + since.Format("2006-01-02"))
+			return
+		}
+
+		groupings := map[string]func(usageRecord) string{
+			"day":     func(r usageRecord) string { return r.Time.Local().Format("2006-01-02") },
+			"persona": func(r usageRecord) string { return r.Persona },
+			"model":   func(r usageRecord) string { return r.Model },
+		}
+		by := []string{"day", "persona", "model"}
+		if usageBy != "" {
+			if _, ok := groupings[usageBy]; !ok {
+				checkError(fmt.Errorf("unknown grouping %q", usageBy), "--by must be day, persona or model", true)
+			}
+			by = []string{usageBy}
+		}
+		for i, grouping := range by {
+			if i > 0 {
+				fmt.Println()
+			}
+			printUsage(grouping, records, groupings[grouping])
+		}
+	},
+}
+
+var (
+	usageBy    string
+	usageSince string
+	usageDays  int
+)
+
+type usageTotal struct {
+	requests int
+	usage    Usage
+	cost     float64
+	unpriced int
+}
+
+// printUsage prints a table of records totalled by key.
+func printUsage(grouping string, records []usageRecord, key func(usageRecord) string) {
+	totals := map[string]*usageTotal{}
+	all := &usageTotal{}
+	for _, record := range records {
+		total, ok := totals[key(record)]
+		if !ok {
+			total = &usageTotal{}
+			totals[key(record)] = total
+		}
+		for _, t := range []*usageTotal{total, all} {
+			t.requests++
+			t.usage = t.usage.Add(record.Usage)
+			t.cost += record.Cost
+			if record.Unpriced {
+				t.unpriced++
+			}
+		}
+	}
+	keys := []string{}
+	for k := range totals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, strings.ToUpper(grouping)+"\tREQUESTS\tPROMPT\tCOMPLETION\tCOST\t")
+	for _, k := range keys {
+		fmt.Fprintln(w, usageRow(k, totals[k]))
+	}
+	fmt.Fprintln(w, usageRow("total", all))
+	w.Flush()
+}
+
+func usageRow(name string, total *usageTotal) string {
+	cost := fmt.Sprintf("%.4f", total.cost)
+	if total.unpriced > 0 {
+		cost += fmt.Sprintf(" (+%d unpriced)", total.unpriced)
+	}
+	tokens := func(n int) string {
+		if total.usage.Estimated {
+			return fmt.Sprintf("~%d", n)
+		}
+		return fmt.Sprint(n)
+	}
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s\t", name, total.requests, tokens(total.usage.PromptTokens), tokens(total.usage.CompletionTokens), cost)
+}
+
+func init() {
+	rootCmd.AddCommand(usageCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// usageCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	usageCmd.Flags().StringVar(&usageBy, "by", "", "only group by day, persona or model")
+	usageCmd.Flags().StringVar(&usageSince, "since", "", "report usage since a date (YYYY-MM-DD)")
+	usageCmd.Flags().IntVar(&usageDays, "days", 30, "report usage for the last number of days")
+}